@@ -1,16 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/XeroAPI/xoauth/pkg/config"
 	"github.com/XeroAPI/xoauth/pkg/connect"
 	"github.com/XeroAPI/xoauth/pkg/db"
 	"github.com/XeroAPI/xoauth/pkg/keyring"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
 	"github.com/XeroAPI/xoauth/pkg/tokens"
 	"github.com/spf13/cobra"
 )
@@ -39,6 +44,32 @@ func getEnv(key, fallback string) string {
 	return value
 }
 
+// parseParameterFlags reads the `--param key=value` flags
+func parseParameterFlags(pairs []string) map[string]string {
+	parameters, parseErr := config.ParseParameters(pairs)
+
+	if parseErr != nil {
+		log.Fatalln(parseErr)
+	}
+
+	return parameters
+}
+
+// readAuthorisationDetailsFlag reads the `--authorization-details` file, if one was given
+func readAuthorisationDetailsFlag(path string) oidc.AuthorisationDetails {
+	if path == "" {
+		return nil
+	}
+
+	details, readErr := config.ReadAuthorisationDetails(path)
+
+	if readErr != nil {
+		log.Fatalln(readErr)
+	}
+
+	return details
+}
+
 func init() {
 	var fallbackPort = 8080
 	var defaultPort, portErr = strconv.Atoi(getEnv("XOAUTH_PORT", fmt.Sprintf("%d", fallbackPort)))
@@ -53,6 +84,11 @@ func init() {
 	var keyringErr error
 	var operatingSystem string = runtime.GOOS
 	var keyRingType string
+	var Offline bool
+	var CacheTTL time.Duration
+	var RequestTimeout time.Duration
+	var MaxRetries int
+	var Transport oidc.TransportOptions
 
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
 		keyringService, keyringErr = keyring.NewKeyRingService(Verbose, keyRingType)
@@ -62,10 +98,31 @@ func init() {
 		if keyringErr != nil {
 			panic(keyringErr)
 		}
+
+		oidc.ConfigureRequests(oidc.RequestOptions{
+			Timeout:    RequestTimeout,
+			MaxRetries: MaxRetries,
+			Transport:  Transport,
+			Context:    interruptContext(),
+		})
+
+		oidc.ConfigureCache(oidc.CacheOptions{
+			Directory: database.GetCacheDir(),
+			TTL:       CacheTTL,
+			Offline:   Offline,
+		})
 	}
 
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "Verbose", "v", false, "Display detailed output")
 	rootCmd.PersistentFlags().StringVarP(&keyRingType, "keyring", "k", runtime.GOOS, "Override the keyring type (darwin, windows)")
+	rootCmd.PersistentFlags().BoolVarP(&Offline, "offline", "", false, "Use cached metadata, keys and tokens, without contacting the provider")
+	rootCmd.PersistentFlags().DurationVarP(&CacheTTL, "cache-ttl", "", oidc.DefaultCacheTTL, "How long to cache provider metadata when the provider doesn't say")
+	rootCmd.PersistentFlags().DurationVarP(&RequestTimeout, "timeout", "", oidc.DefaultRequestTimeout, "How long a request to the provider may take")
+	rootCmd.PersistentFlags().IntVarP(&MaxRetries, "retries", "", oidc.DefaultMaxRetries, "How many times to retry a request when the provider is rate limiting or unavailable")
+	rootCmd.PersistentFlags().StringVarP(&Transport.Proxy, "proxy", "", "", "Reach the provider through this HTTP(S) proxy, instead of the connection's or HTTPS_PROXY")
+	rootCmd.PersistentFlags().StringVarP(&Transport.CACertificateFile, "ca-cert", "", "", "Trust the CA certificates in this PEM file as well as the system's")
+	rootCmd.PersistentFlags().StringVarP(&Transport.MinTLSVersion, "min-tls", "", "", "The lowest TLS version to use with the provider: 1.0, 1.1, 1.2 or 1.3")
+	rootCmd.PersistentFlags().BoolVarP(&Transport.InsecureSkipVerify, "insecure-skip-verify", "", false, "Don't verify the provider's TLS certificate. Only for local test servers")
 
 	var ShowSecrets bool
 	var listCmd = &cobra.Command{
@@ -94,6 +151,9 @@ func init() {
 
 	var DryRun bool
 	var Port int
+	var MaxAge int
+	var Parameters []string
+	var AuthorisationDetailsPath string
 
 	var connectCmd = &cobra.Command{
 		Use:   "connect [connection_name]",
@@ -101,7 +161,7 @@ func init() {
 		Args:  config.ValidateClientNameCmdArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) == 1 {
-				connect.Authorise(database, args[0], operatingSystem, DryRun, Port)
+				connect.Authorise(database, args[0], operatingSystem, DryRun, Port, MaxAge, parseParameterFlags(Parameters), readAuthorisationDetailsFlag(AuthorisationDetailsPath))
 				return
 			}
 
@@ -111,12 +171,15 @@ func init() {
 				panic(err)
 			}
 
-			connect.Authorise(database, connection, operatingSystem, DryRun, Port)
+			connect.Authorise(database, connection, operatingSystem, DryRun, Port, MaxAge, parseParameterFlags(Parameters), readAuthorisationDetailsFlag(AuthorisationDetailsPath))
 		},
 	}
 
 	connectCmd.PersistentFlags().BoolVarP(&DryRun, "dry-run", "d", false, "Output the authorisation request URL instead of perforiming the request")
 	connectCmd.PersistentFlags().IntVarP(&Port, "port", "p", defaultPort, "Localhost port")
+	connectCmd.PersistentFlags().IntVarP(&MaxAge, "max-age", "", oidc.NoMaxAge, "Ask the user to sign in again if they last authenticated more than this many seconds ago")
+	connectCmd.PersistentFlags().StringArrayVarP(&Parameters, "param", "", nil, "Send an extra `key=value` parameter with the authorisation request, replacing the connection's saved one. Can be repeated")
+	connectCmd.PersistentFlags().StringVarP(&AuthorisationDetailsPath, "authorization-details", "", "", "Request the authorization_details in this JSON file, instead of the connection's saved ones")
 
 	var deleteCmd = &cobra.Command{
 		Use:   "delete [connection]",
@@ -169,6 +232,97 @@ func init() {
 		},
 	}
 
+	var allowedAlgorithmsCmd = &cobra.Command{
+		Use:   "allowed-algorithms [clientName] [...algorithms]",
+		Short: "Only accept tokens signed with these algorithms. Leave the list empty to accept those the provider advertises",
+		Args:  config.ValidateAlgorithmsCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.SetAllowedAlgorithms(database, args[0], args[1:]...)
+		},
+	}
+
+	var accessTokenValidationCmd = &cobra.Command{
+		Use:   "access-token-validation [clientName] [none|jwt|introspect|signature] [audience]",
+		Short: "Choose how access tokens are validated, and optionally the audience a JWT access token must be for",
+		Args:  config.ValidateAccessTokenValidationCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var audience string
+
+			if len(args) > 2 {
+				audience = args[2]
+			}
+
+			config.UpdateAccessTokenValidation(database, args[0], args[1], audience)
+		},
+	}
+
+	var setParamCmd = &cobra.Command{
+		Use:   "set-param [clientName] [authorize|token] [...key=value]",
+		Short: "Send extra parameters, such as prompt or a provider's audience, with the authorisation request or token requests",
+		Args:  config.ValidateSetParameterCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			parameters, _ := config.ParseParameters(args[2:])
+			config.SetParameters(database, args[0], args[1], parameters)
+		},
+	}
+
+	var removeParamCmd = &cobra.Command{
+		Use:   "remove-param [clientName] [authorize|token] [...keys]",
+		Short: "Stop sending extra parameters with the authorisation request or token requests",
+		Args:  config.ValidateRemoveParameterCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.RemoveParameters(database, args[0], args[1], args[2:]...)
+		},
+	}
+
+	var transportCmd = &cobra.Command{
+		Use:   "transport [clientName] [proxy|ca-cert|min-tls|insecure-skip-verify] [value]",
+		Short: "Configure a proxy, extra CA certificates or TLS options for reaching the provider. Leave out the value to clear the setting",
+		Args:  config.ValidateTransportCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var value string
+
+			if len(args) > 2 {
+				value = args[2]
+			}
+
+			config.SetTransportOption(database, args[0], args[1], value)
+		},
+	}
+
+	var addResourceCmd = &cobra.Command{
+		Use:   "add-resource [clientName] [...resources]",
+		Short: "Add the URIs of APIs the connection's tokens are for (resource indicators)",
+		Args:  config.ValidateResourceCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.AddResource(database, args[0], args[1:]...)
+		},
+	}
+
+	var removeResourceCmd = &cobra.Command{
+		Use:   "remove-resource [clientName] [...resources]",
+		Short: "Remove resources from a connection, along with their saved tokens",
+		Args:  config.ValidateResourceCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.RemoveResource(database, args[0], args[1:]...)
+		},
+	}
+
+	var authorisationDetailsCmd = &cobra.Command{
+		Use:   "authorization-details [clientName] [file or JSON]",
+		Short: "Request structured authorization_details (RFC 9396) for a connection. Leave out the details to stop requesting them",
+		Args:  config.ValidateAuthorisationDetailsCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var details oidc.AuthorisationDetails
+
+			if len(args) > 1 {
+				details, _ = config.ReadAuthorisationDetails(args[1])
+			}
+
+			config.SetAuthorisationDetails(database, args[0], details)
+		},
+	}
+
 	var updateSecretCmd = &cobra.Command{
 		Use:   "update-secret [clientName] [clientSecret]",
 		Short: "Update the client secret for a connection",
@@ -178,30 +332,117 @@ func init() {
 		},
 	}
 
+	var updateKeyCmd = &cobra.Command{
+		Use:   "update-key [clientName] [privateKeyPath]",
+		Short: "Authenticate a connection with a private key (private_key_jwt), stored in your OS keychain",
+		Args:  config.ValidateKeyCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.UpdatePrivateKey(database, args[0], args[1])
+		},
+	}
+
+	var updateCertificateCmd = &cobra.Command{
+		Use:   "update-certificate [clientName] [certificatePath] [keyPath]",
+		Short: "Present a client certificate over mutual TLS, with its private key stored in your OS keychain",
+		Args:  config.ValidateCertificateCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.UpdateCertificate(database, args[0], args[1], args[2])
+		},
+	}
+
 	var EnvFlag bool
 	var ForceRefresh bool
+	var DPoPMethod string
+	var DPoPUrl string
+	var Resource string
+	var RefreshScope string
 
 	var tokenCmd = &cobra.Command{
 		Use:   "token [clientName]",
 		Short: "Get the last saved set of tokens out of the keychain",
 		Run: func(cmd *cobra.Command, args []string) {
+			var client string
+
 			if len(args) == 1 {
-				tokens.ShowTokens(database, args[0], EnvFlag, ForceRefresh)
-				return
+				client = args[0]
+			} else {
+				var err error
+				client, err = config.ChooseClient(database)
+
+				if err != nil {
+					log.Fatalln(err)
+				}
 			}
 
-			client, err := config.ChooseClient(database)
-
-			if err != nil {
-				log.Fatalln(err)
+			if DPoPUrl != "" {
+				tokens.ShowDPoPProof(database, client, Resource, DPoPMethod, DPoPUrl, ForceRefresh)
+				return
 			}
 
-			tokens.ShowTokens(database, client, EnvFlag, ForceRefresh)
+			tokens.ShowTokens(database, client, Resource, RefreshScope, EnvFlag, ForceRefresh)
 		},
 	}
 
 	tokenCmd.PersistentFlags().BoolVarP(&EnvFlag, "env", "e", false, "Export tokens to environment")
 	tokenCmd.PersistentFlags().BoolVarP(&ForceRefresh, "refresh", "r", false, "Force a token refresh")
+	tokenCmd.PersistentFlags().StringVarP(&DPoPUrl, "dpop-url", "", "", "Print a DPoP proof for calling this URL with the access token, instead of the tokens")
+	tokenCmd.PersistentFlags().StringVarP(&DPoPMethod, "dpop-method", "", "GET", "The HTTP method for the DPoP proof")
+	tokenCmd.PersistentFlags().StringVarP(&Resource, "resource", "", "", "Get an access token restricted to one of the connection's resources, instead of its main tokens")
+	tokenCmd.PersistentFlags().StringVarP(&RefreshScope, "scope", "", "", "Refresh the tokens, asking for only these space separated scopes")
+
+	var UserInfoRefresh bool
+
+	var userInfoCmd = &cobra.Command{
+		Use:     "userinfo [clientName]",
+		Aliases: []string{"whoami"},
+		Short:   "Show who the saved tokens belong to, using the provider's userinfo endpoint",
+		Run: func(cmd *cobra.Command, args []string) {
+			var client string
+
+			if len(args) == 1 {
+				client = args[0]
+			} else {
+				var err error
+				client, err = config.ChooseClient(database)
+
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			tokens.UserInfo(database, client, UserInfoRefresh)
+		},
+	}
+
+	userInfoCmd.PersistentFlags().BoolVarP(&UserInfoRefresh, "refresh", "r", false, "Force a token refresh first")
+
+	var LogoutDryRun bool
+	var LogoutPort int
+
+	var logoutCmd = &cobra.Command{
+		Use:   "logout [clientName]",
+		Short: "Sign out at the provider, then clear the saved tokens",
+		Args:  config.ValidateClientNameCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var client string
+
+			if len(args) == 1 {
+				client = args[0]
+			} else {
+				var err error
+				client, err = config.ChooseClient(database)
+
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			connect.Logout(database, client, operatingSystem, LogoutDryRun, LogoutPort)
+		},
+	}
+
+	logoutCmd.PersistentFlags().BoolVarP(&LogoutDryRun, "dry-run", "d", false, "Output the logout request URL instead of performing the request")
+	logoutCmd.PersistentFlags().IntVarP(&LogoutPort, "port", "p", defaultPort, "Localhost port")
 
 	var cleanCmd = &cobra.Command{
 		Use:   "clean [connection]",
@@ -222,6 +463,90 @@ func init() {
 		},
 	}
 
+	var LocalOnly bool
+
+	var revokeCmd = &cobra.Command{
+		Use:   "revoke [connection]",
+		Short: "Revokes the tokens associated with a connection at the provider, then removes them from your local machine",
+		Args:  config.ValidateClientNameCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 {
+				tokens.RevokeTokens(database, args[0], LocalOnly)
+				return
+			}
+
+			connection, err := config.ChooseClient(database)
+
+			if err != nil {
+				panic(err)
+			}
+
+			tokens.RevokeTokens(database, connection, LocalOnly)
+		},
+	}
+
+	revokeCmd.PersistentFlags().BoolVarP(&LocalOnly, "local-only", "l", false, "Only remove the tokens from your local machine, without revoking them at the provider")
+
+	var IntrospectToken string
+	var IntrospectTable bool
+
+	var introspectCmd = &cobra.Command{
+		Use:   "introspect [connection]",
+		Short: "Ask the provider whether a token is active, and what it grants",
+		Args:  config.ValidateClientNameCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 {
+				tokens.Introspect(database, args[0], IntrospectToken, IntrospectTable)
+				return
+			}
+
+			connection, err := config.ChooseClient(database)
+
+			if err != nil {
+				panic(err)
+			}
+
+			tokens.Introspect(database, connection, IntrospectToken, IntrospectTable)
+		},
+	}
+
+	introspectCmd.PersistentFlags().StringVarP(&IntrospectToken, "token", "t", "", "Introspect this token instead of the stored access token")
+	introspectCmd.PersistentFlags().BoolVarP(&IntrospectTable, "table", "", false, "Print the result as a table instead of JSON")
+
+	var Exchange tokens.ExchangeOptions
+
+	var exchangeCmd = &cobra.Command{
+		Use:   "exchange [connection]",
+		Short: "Exchange a subject token for a new token, using a connection's client",
+		Args:  config.ValidateClientNameCmdArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 {
+				tokens.Exchange(database, args[0], Exchange)
+				return
+			}
+
+			connection, err := config.ChooseClient(database)
+
+			if err != nil {
+				panic(err)
+			}
+
+			tokens.Exchange(database, connection, Exchange)
+		},
+	}
+
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.SubjectConnection, "subject-from", "", "", "Use the token stored for this connection as the subject token")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.SubjectFile, "subject-token-file", "", "", "Read the subject token from this file, or `-` for stdin")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.SubjectTokenType, "subject-token-type", "", "access_token", "The subject token type: access_token, refresh_token, id_token, jwt or a URN")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.ActorConnection, "actor-from", "", "", "Use the token stored for this connection as the actor token")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.ActorFile, "actor-token-file", "", "", "Read the actor token from this file, or `-` for stdin")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.ActorTokenType, "actor-token-type", "", "access_token", "The actor token type")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.Audience, "audience", "", "", "The service the new token is for")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.Resource, "resource", "", "", "The URI of the resource the new token is for")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.RequestedTokenType, "requested-token-type", "", "", "The type of token to ask for")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.Scope, "scope", "", "", "The scopes to ask for, space separated")
+	exchangeCmd.PersistentFlags().StringVarP(&Exchange.SaveAs, "save", "", "", "Save the new token under this connection")
+
 	var DoctorPort int
 
 	var doctorCmd = &cobra.Command{
@@ -236,7 +561,17 @@ func init() {
 
 	setupCmd.AddCommand(addScopeCmd)
 	setupCmd.AddCommand(removeScopeCmd)
+	setupCmd.AddCommand(allowedAlgorithmsCmd)
+	setupCmd.AddCommand(accessTokenValidationCmd)
+	setupCmd.AddCommand(setParamCmd)
+	setupCmd.AddCommand(removeParamCmd)
+	setupCmd.AddCommand(authorisationDetailsCmd)
+	setupCmd.AddCommand(transportCmd)
+	setupCmd.AddCommand(addResourceCmd)
+	setupCmd.AddCommand(removeResourceCmd)
 	setupCmd.AddCommand(updateSecretCmd)
+	setupCmd.AddCommand(updateKeyCmd)
+	setupCmd.AddCommand(updateCertificateCmd)
 
 	rootCmd.Version = "1.1.0"
 
@@ -247,9 +582,32 @@ func init() {
 	rootCmd.AddCommand(deleteCmd)
 	rootCmd.AddCommand(doctorCmd)
 	rootCmd.AddCommand(tokenCmd)
+	rootCmd.AddCommand(userInfoCmd)
+	rootCmd.AddCommand(logoutCmd)
 	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(revokeCmd)
+	rootCmd.AddCommand(introspectCmd)
+	rootCmd.AddCommand(exchangeCmd)
 }
 
 func Execute() error {
 	return rootCmd.Execute()
 }
+
+// interruptContext is cancelled by Ctrl-C, so requests and retries in flight stop. A second Ctrl-C
+// exits straight away, as usual
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		log.Println("Cancelling")
+		cancel()
+	}()
+
+	return ctx
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+func ValidateAccessTokenValidationCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("please supply a client name and a validation policy, e.g, `xero jwt`")
+	}
+
+	if !Contains(oidc.AccessTokenValidationPolicies, args[1]) {
+		return fmt.Errorf("unknown validation policy %q. Use one of %s", args[1], strings.Join(oidc.AccessTokenValidationPolicies, ", "))
+	}
+
+	return nil
+}
+
+// askForAccessTokenValidation prompts for how access tokens are validated, and the audience they should be for
+func askForAccessTokenValidation() (string, string, error) {
+	var validationResult string
+	validation := &survey.Select{
+		Message: "How should access tokens be validated?",
+		Options: oidc.AccessTokenValidationPolicies,
+		Default: oidc.AccessTokenValidationNone,
+	}
+
+	validationErr := survey.AskOne(validation, &validationResult)
+
+	if validationErr != nil {
+		return "", "", validationErr
+	}
+
+	var audienceResult string
+
+	if validationResult == oidc.AccessTokenValidationJwt {
+		audience := &survey.Input{
+			Message: "Expected access token audience (aud), blank to accept any:",
+		}
+
+		audienceErr := survey.AskOne(audience, &audienceResult)
+
+		if audienceErr != nil {
+			return "", "", audienceErr
+		}
+	}
+
+	return validationResult, audienceResult, nil
+}
+
+func UpdateAccessTokenValidation(database *db.CredentialStore, clientName string, validation string, audience string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	client.AccessTokenValidation = validation
+	client.AccessTokenAudience = audience
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	log.Printf("Access tokens for %q will be validated with the %s policy", clientName, validation)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+func ValidateAlgorithmsCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+
+	for _, alg := range args[1:] {
+		if !oidc.IsSupportedSigningAlgorithm(alg) {
+			return errors.New("unsupported algorithm " + alg + ". Use RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA")
+		}
+	}
+
+	return nil
+}
+
+// SetAllowedAlgorithms limits the algorithms tokens for a connection may be signed with.
+// With no algorithms, it goes back to accepting those the provider advertises
+func SetAllowedAlgorithms(database *db.CredentialStore, clientName string, algorithms ...string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	client.AllowedAlgorithms = algorithms
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	if len(algorithms) == 0 {
+		log.Println("Accepting the algorithms the provider advertises")
+		return
+	}
+
+	log.Printf("Allowed algorithms are: \n • %s", strings.Join(client.AllowedAlgorithms, "\n • "))
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+)
+
+func validateLifetime(val interface{}) error {
+	lifetime, err := strconv.Atoi(val.(string))
+
+	if err != nil || lifetime <= 0 {
+		return errors.New("lifetime must be a positive number of seconds")
+	}
+
+	return nil
+}
+
+func validateClaims(val interface{}) error {
+	if val.(string) == "" {
+		return nil
+	}
+
+	var claims map[string]interface{}
+
+	if err := json.Unmarshal([]byte(val.(string)), &claims); err != nil {
+		return errors.New("extra claims must be a JSON object, e.g, {\"scope\": \"read\"}")
+	}
+
+	return nil
+}
+
+// askForAssertion prompts for the claims of the jwt_bearer grant's assertion
+func askForAssertion() (oidc.JwtBearerAssertion, error) {
+	var result oidc.JwtBearerAssertion
+
+	questions := []*survey.Question{
+		{
+			Name:   "issuer",
+			Prompt: &survey.Input{Message: "Assertion issuer (iss), blank for the client_id:"},
+		},
+		{
+			Name:   "subject",
+			Prompt: &survey.Input{Message: "Assertion subject (sub), blank for the client_id:"},
+		},
+		{
+			Name:   "audience",
+			Prompt: &survey.Input{Message: "Assertion audience (aud), blank for the token endpoint:"},
+		},
+		{
+			Name:     "lifetime",
+			Prompt:   &survey.Input{Message: "Assertion lifetime in seconds:", Default: "300"},
+			Validate: validateLifetime,
+		},
+		{
+			Name:     "claims",
+			Prompt:   &survey.Input{Message: "Extra claims as a JSON object (optional):"},
+			Validate: validateClaims,
+		},
+	}
+
+	answers := struct {
+		Issuer   string
+		Subject  string
+		Audience string
+		Lifetime string
+		Claims   string
+	}{}
+
+	askErr := survey.Ask(questions, &answers)
+
+	if askErr != nil {
+		return result, askErr
+	}
+
+	result.Issuer = answers.Issuer
+	result.Subject = answers.Subject
+	result.Audience = answers.Audience
+	result.Lifetime, _ = strconv.Atoi(answers.Lifetime)
+
+	if answers.Claims != "" {
+		// Already validated above
+		_ = json.Unmarshal([]byte(answers.Claims), &result.Claims)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+func ValidateAuthorisationDetailsCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+
+	if len(args) > 1 {
+		_, readErr := ReadAuthorisationDetails(args[1])
+		return readErr
+	}
+
+	return nil
+}
+
+// ReadAuthorisationDetails reads authorization_details from inline JSON, or from the JSON file at the given path
+func ReadAuthorisationDetails(source string) (oidc.AuthorisationDetails, error) {
+	var data = []byte(source)
+	var trimmed = strings.TrimSpace(source)
+
+	if !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{") {
+		var readErr error
+		data, readErr = ioutil.ReadFile(source)
+
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	return oidc.ParseAuthorisationDetails(data)
+}
+
+// SetAuthorisationDetails saves the authorization_details a connection requests. With none, only scopes are requested
+func SetAuthorisationDetails(database *db.CredentialStore, clientName string, details oidc.AuthorisationDetails) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	client.AuthorisationDetails = details
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	if len(details) == 0 {
+		log.Println("No authorization_details are requested")
+		return
+	}
+
+	encoded, _ := details.Encode()
+	log.Printf("authorization_details are: %s", encoded)
+}
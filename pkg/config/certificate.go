@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+func ValidateCertificateCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+
+	if len(args) < 3 {
+		return errors.New("please supply the paths to a PEM encoded certificate and its private key, e.g, `./client.crt ./client.key`")
+	}
+
+	return nil
+}
+
+func readCertificateFiles(certificatePath string, keyPath string) (string, string, error) {
+	certificate, certificateErr := ioutil.ReadFile(certificatePath)
+
+	if certificateErr != nil {
+		return "", "", certificateErr
+	}
+
+	certificateKey, keyErr := ioutil.ReadFile(keyPath)
+
+	if keyErr != nil {
+		return "", "", keyErr
+	}
+
+	validateErr := oidc.ValidateClientCertificate(string(certificate), string(certificateKey))
+
+	if validateErr != nil {
+		return "", "", validateErr
+	}
+
+	return string(certificate), string(certificateKey), nil
+}
+
+func askForCertificate() (string, string, error) {
+	var certificatePathResult string
+	certificatePath := &survey.Input{
+		Message: "Path to your PEM encoded client certificate:",
+	}
+
+	certificateErr := survey.AskOne(certificatePath, &certificatePathResult, survey.WithValidator(survey.Required))
+
+	if certificateErr != nil {
+		return "", "", certificateErr
+	}
+
+	var keyPathResult string
+	keyPath := &survey.Input{
+		Message: "Path to the certificate's PEM encoded private key:",
+	}
+
+	validateKeyPath := func(val interface{}) error {
+		_, _, err := readCertificateFiles(certificatePathResult, val.(string))
+		return err
+	}
+
+	keyErr := survey.AskOne(keyPath, &keyPathResult, survey.WithValidator(validateKeyPath))
+
+	if keyErr != nil {
+		return "", "", keyErr
+	}
+
+	return readCertificateFiles(certificatePathResult, keyPathResult)
+}
+
+func UpdateCertificate(database *db.CredentialStore, clientName string, certificatePath string, keyPath string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	certificate, certificateKey, readErr := readCertificateFiles(certificatePath, keyPath)
+
+	if readErr != nil {
+		log.Fatal(readErr)
+	}
+
+	_, saveErr := database.SetClientCertificate(client, certificate, certificateKey)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	log.Printf("Updated client certificate for %s\n", client.Alias)
+}
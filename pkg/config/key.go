@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"log"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+func ValidateKeyCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+
+	if len(args) < 2 {
+		return errors.New("please supply the path to a PEM encoded private key, e.g, `./private.pem`")
+	}
+
+	return nil
+}
+
+func UpdatePrivateKey(database *db.CredentialStore, clientName string, privateKeyPath string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	privateKey, readErr := readPrivateKeyFile(privateKeyPath)
+
+	if readErr != nil {
+		log.Fatal(readErr)
+	}
+
+	var previousAuthMethod = client.AuthMethod
+
+	client.AuthMethod = oidc.PrivateKeyJwt
+
+	_, saveErr := database.SaveClientWithPrivateKey(client, privateKey)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	// The client no longer authenticates with a secret, so it's removed rather than left behind in the keychain.
+	// Public clients and those authenticating with their certificate have no secret to remove
+	if previousAuthMethod != oidc.PrivateKeyJwt && previousAuthMethod != oidc.TlsClientAuth {
+		_, deleteErr := database.DeleteClientSecret(client.Alias)
+
+		if deleteErr != nil {
+			log.Printf("No client secret to delete for %s", client.Alias)
+		}
+	}
+
+	log.Printf("Updated private key for %s\n", client.Alias)
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
 	"github.com/gookit/color"
 )
 
@@ -42,13 +43,42 @@ func ListAll(database *db.CredentialStore, showSecrets bool) {
 }
 
 func print_info(value db.OidcClient, clientSecret string) {
-	fmt.Fprintf(os.Stderr, "%s: %s\nclient_id: %s\ngrant_type: %s\nclient_secret: %s\nauthority: %s\nscopes:\n  • %s\n\n",
+	var authMethod = value.AuthMethod
+
+	if authMethod == "" {
+		authMethod = oidc.ClientSecretBasic
+	}
+
+	var accessTokenValidation = value.AccessTokenValidationPolicy()
+
+	var extraSettings string
+
+	if len(value.AuthorisationParameters) > 0 {
+		extraSettings += fmt.Sprintf("authorize_parameters: %s\n", color.Cyan.Sprint(strings.Join(FormatParameters(value.AuthorisationParameters), " ")))
+	}
+
+	if len(value.Resources) > 0 {
+		extraSettings += fmt.Sprintf("resources: %s\n", color.Cyan.Sprint(strings.Join(value.Resources, " ")))
+	}
+
+	if len(value.TokenParameters) > 0 {
+		extraSettings += fmt.Sprintf("token_parameters: %s\n", color.Cyan.Sprint(strings.Join(FormatParameters(value.TokenParameters), " ")))
+	}
+
+	if transport := FormatTransport(value.Transport); len(transport) > 0 {
+		extraSettings += fmt.Sprintf("transport: %s\n", color.Cyan.Sprint(strings.Join(transport, " ")))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\nclient_id: %s\ngrant_type: %s\nauth_method: %s\nclient_secret: %s\nauthority: %s\naccess_token_validation: %s\n%sscopes:\n  • %s\n\n",
 		color.White.Sprintf("name"),
 		color.Green.Sprintf(value.Alias),
 		color.Cyan.Sprintf(value.ClientId),
 		color.Cyan.Sprintf(value.GrantType),
+		color.Cyan.Sprintf(authMethod),
 		color.Cyan.Sprintf(clientSecret),
 		color.Yellow.Sprintf(value.Authority),
+		color.Cyan.Sprintf(accessTokenValidation),
+		extraSettings,
 		strings.Join(value.Scopes, "\n  • "),
 	)
 }
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/spf13/cobra"
+)
+
+// Which request the extra parameters are sent with
+const AuthoriseParameters = "authorize"
+const TokenParameters = "token"
+
+func ValidateSetParameterCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+	if len(args) < 2 || (args[1] != AuthoriseParameters && args[1] != TokenParameters) {
+		return fmt.Errorf("please say which request the parameters are for: %s or %s", AuthoriseParameters, TokenParameters)
+	}
+	if len(args) < 3 {
+		return errors.New("please supply at least one parameter, e.g, `prompt=login`")
+	}
+
+	_, parseErr := ParseParameters(args[2:])
+
+	return parseErr
+}
+
+func ValidateRemoveParameterCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+	if len(args) < 2 || (args[1] != AuthoriseParameters && args[1] != TokenParameters) {
+		return fmt.Errorf("please say which request the parameters are for: %s or %s", AuthoriseParameters, TokenParameters)
+	}
+	if len(args) < 3 {
+		return errors.New("please supply at least one parameter name, e.g, `prompt`")
+	}
+	return nil
+}
+
+// ParseParameters reads `key=value` pairs. The value may be empty, or contain further `=` signs
+func ParseParameters(pairs []string) (map[string]string, error) {
+	var parameters = map[string]string{}
+
+	for _, pair := range pairs {
+		separator := strings.Index(pair, "=")
+
+		if separator < 1 {
+			return nil, fmt.Errorf("expected a parameter like `key=value`, but got %q", pair)
+		}
+
+		parameters[pair[:separator]] = pair[separator+1:]
+	}
+
+	return parameters, nil
+}
+
+func SetParameters(database *db.CredentialStore, clientName string, request string, parameters map[string]string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	var existing = client.AuthorisationParameters
+
+	if request == TokenParameters {
+		existing = client.TokenParameters
+	}
+
+	if existing == nil {
+		existing = map[string]string{}
+	}
+
+	for key, value := range parameters {
+		existing[key] = value
+	}
+
+	saveParameters(database, client, request, existing)
+}
+
+func RemoveParameters(database *db.CredentialStore, clientName string, request string, keys ...string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	var existing = client.AuthorisationParameters
+
+	if request == TokenParameters {
+		existing = client.TokenParameters
+	}
+
+	for _, key := range keys {
+		delete(existing, key)
+	}
+
+	saveParameters(database, client, request, existing)
+}
+
+func saveParameters(database *db.CredentialStore, client db.OidcClient, request string, parameters map[string]string) {
+	if request == TokenParameters {
+		client.TokenParameters = parameters
+	} else {
+		client.AuthorisationParameters = parameters
+	}
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	if len(parameters) == 0 {
+		log.Printf("No extra %s parameters are sent", request)
+		return
+	}
+
+	log.Printf("Extra %s parameters are: \n • %s", request, strings.Join(FormatParameters(parameters), "\n • "))
+}
+
+// FormatParameters lists the parameters as `key=value`, sorted by key
+func FormatParameters(parameters map[string]string) []string {
+	var formatted []string
+
+	for key, value := range parameters {
+		formatted = append(formatted, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	sort.Strings(formatted)
+
+	return formatted
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseParameters(t *testing.T) {
+	var cases = []struct {
+		pairs    []string
+		expected map[string]string
+		valid    bool
+	}{
+		{[]string{"prompt=login"}, map[string]string{"prompt": "login"}, true},
+		{[]string{"prompt=login", "ui_locales=en-NZ"}, map[string]string{"prompt": "login", "ui_locales": "en-NZ"}, true},
+		{[]string{"acr_values="}, map[string]string{"acr_values": ""}, true},
+		{[]string{"claims=a=b"}, map[string]string{"claims": "a=b"}, true},
+		{[]string{"prompt=none", "prompt=login"}, map[string]string{"prompt": "login"}, true},
+		{[]string{"prompt"}, nil, false},
+		{[]string{"=login"}, nil, false},
+	}
+
+	for _, c := range cases {
+		actual, parseErr := ParseParameters(c.pairs)
+
+		if (parseErr == nil) != c.valid {
+			t.Errorf("ParseParameters(%q) = %v, expected valid: %t", c.pairs, parseErr, c.valid)
+			continue
+		}
+
+		if c.valid && !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("ParseParameters(%q) = %v, expected %v", c.pairs, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/spf13/cobra"
+)
+
+func ValidateResourceCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+	if len(args) < 2 {
+		return errors.New("please supply at least one resource, e.g, `https://api.xero.com`")
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc8707#section-2
+	for _, resource := range args[1:] {
+		resourceUrl, parseErr := url.Parse(resource)
+
+		if parseErr != nil || !resourceUrl.IsAbs() {
+			return fmt.Errorf("the resource %q must be an absolute URI", resource)
+		}
+
+		if resourceUrl.Fragment != "" || strings.Contains(resource, "#") {
+			return fmt.Errorf("the resource %q mustn't have a fragment", resource)
+		}
+	}
+
+	return nil
+}
+
+func AddResource(database *db.CredentialStore, clientName string, resources ...string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	for _, resource := range resources {
+		if Contains(client.Resources, resource) {
+			continue
+		}
+
+		client.Resources = append(client.Resources, resource)
+	}
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	log.Printf("Resources are: \n • %s", strings.Join(client.Resources, "\n • "))
+	log.Println("Connect again, so the user can agree to the new resources")
+}
+
+func RemoveResource(database *db.CredentialStore, clientName string, resources ...string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	var newResources []string
+
+	for _, resource := range client.Resources {
+		if Contains(resources, resource) {
+			// The resource's token may never have been requested
+			database.DeleteResourceTokens(clientName, resource)
+			continue
+		}
+
+		newResources = append(newResources, resource)
+	}
+
+	client.Resources = newResources
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	if len(client.Resources) == 0 {
+		log.Println("The connection has no resources")
+		return
+	}
+
+	log.Printf("Resources are: \n • %s", strings.Join(client.Resources, "\n • "))
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
 	"github.com/spf13/cobra"
 )
 
@@ -39,5 +40,25 @@ func UpdateSecret(database *db.CredentialStore, clientName string, clientSecret
 		log.Fatal(secretErr)
 	}
 
+	// A new secret means the client authenticates with it, rather than a private key
+	if client.AuthMethod == oidc.PrivateKeyJwt {
+		client.AuthMethod = oidc.ClientSecretBasic
+
+		_, saveErr := database.SaveClientMetadata(client)
+
+		if saveErr != nil {
+			log.Fatal(saveErr)
+		}
+
+		// JWT bearer connections still sign their assertions with the key
+		if client.GrantType != oidc.JwtBearer {
+			_, deleteErr := database.DeleteClientPrivateKey(client.Alias)
+
+			if deleteErr != nil {
+				log.Printf("No private key to delete for %s", client.Alias)
+			}
+		}
+	}
+
 	log.Printf("Updated client secret for %s\n", client.Alias)
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"regexp"
@@ -40,6 +41,28 @@ func validateAuthority(val interface{}) error {
 	return nil
 }
 
+func readPrivateKeyFile(path string) (string, error) {
+	data, readErr := ioutil.ReadFile(path)
+
+	if readErr != nil {
+		return "", readErr
+	}
+
+	_, parseErr := oidc.ParsePrivateKey(string(data))
+
+	if parseErr != nil {
+		return "", parseErr
+	}
+
+	return string(data), nil
+}
+
+func validatePrivateKeyFile(val interface{}) error {
+	_, err := readPrivateKeyFile(val.(string))
+
+	return err
+}
+
 func ValidateName(val interface{}) error {
 	var aliasRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
@@ -145,7 +168,7 @@ func InteractiveSetup(database *db.CredentialStore, clientName string, defaultPo
 	var grantTypeResult string
 	grantType := &survey.Select{
 		Message: "Select Grant Type:",
-		Options: []string{oidc.AuthorisationCode, oidc.PKCE, oidc.ClientCredentials},
+		Options: []string{oidc.AuthorisationCode, oidc.PKCE, oidc.Hybrid, oidc.Implicit, oidc.ClientCredentials, oidc.DeviceCode, oidc.JwtBearer},
 	}
 
 	grantTypeErr := survey.AskOne(grantType, &grantTypeResult)
@@ -155,6 +178,76 @@ func InteractiveSetup(database *db.CredentialStore, clientName string, defaultPo
 		return
 	}
 
+	var authMethodResult = ""
+
+	if grantTypeResult != oidc.PKCE && grantTypeResult != oidc.Implicit {
+		authMethod := &survey.Select{
+			Message: "Select client authentication method:",
+			Options: []string{oidc.ClientSecretBasic, oidc.PrivateKeyJwt, oidc.TlsClientAuth},
+		}
+
+		authMethodErr := survey.AskOne(authMethod, &authMethodResult)
+
+		if authMethodErr != nil {
+			log.Printf("Prompt failed %v\n", authMethodErr)
+			return
+		}
+	}
+
+	var privateKeyResult string
+
+	// The jwt_bearer grant signs its assertion with the private key, whichever way the client authenticates
+	if authMethodResult == oidc.PrivateKeyJwt || grantTypeResult == oidc.JwtBearer {
+		var privateKeyPathResult string
+		privateKeyPath := &survey.Input{
+			Message: "Path to your PEM encoded private key:",
+		}
+
+		privateKeyErr := survey.AskOne(privateKeyPath, &privateKeyPathResult, survey.WithValidator(validatePrivateKeyFile))
+
+		if privateKeyErr != nil {
+			log.Printf("Prompt failed %v\n", privateKeyErr)
+			return
+		}
+
+		privateKeyResult, privateKeyErr = readPrivateKeyFile(privateKeyPathResult)
+
+		if privateKeyErr != nil {
+			log.Printf("Unable to read private key %v\n", privateKeyErr)
+			return
+		}
+	}
+
+	// tls_client_auth needs a certificate, but any client can present one for certificate-bound tokens
+	var useCertificateResult = authMethodResult == oidc.TlsClientAuth
+
+	if !useCertificateResult {
+		useCertificate := &survey.Confirm{
+			Message: "Present a client certificate (mutual TLS)?",
+		}
+
+		useCertificateErr := survey.AskOne(useCertificate, &useCertificateResult)
+
+		if useCertificateErr != nil {
+			log.Printf("Prompt failed %v\n", useCertificateErr)
+			return
+		}
+	}
+
+	var certificateResult string
+	var certificateKeyResult string
+
+	if useCertificateResult {
+		var certificateErr error
+
+		certificateResult, certificateKeyResult, certificateErr = askForCertificate()
+
+		if certificateErr != nil {
+			log.Printf("Prompt failed %v\n", certificateErr)
+			return
+		}
+	}
+
 	var clientSecretLabel = "What's your client_secret?"
 	var clientSecretResult string
 	var clientSecretErr error
@@ -163,7 +256,17 @@ func InteractiveSetup(database *db.CredentialStore, clientName string, defaultPo
 		Message: clientSecretLabel,
 	}
 
-	if grantTypeResult != oidc.PKCE {
+	// PKCE and implicit clients have no secret, and private_key_jwt and tls_client_auth clients authenticate with their keys instead
+	var needsSecret = grantTypeResult != oidc.PKCE &&
+		grantTypeResult != oidc.Implicit &&
+		authMethodResult != oidc.PrivateKeyJwt &&
+		authMethodResult != oidc.TlsClientAuth
+
+	if needsSecret && (grantTypeResult == oidc.DeviceCode || grantTypeResult == oidc.JwtBearer) {
+		// Devices and assertion grants are often public clients, so the secret is optional
+		clientSecret.Message = "What's your client_secret? (leave blank for a public client)"
+		clientSecretErr = survey.AskOne(clientSecret, &clientSecretResult)
+	} else if needsSecret {
 		clientSecretErr = survey.AskOne(clientSecret, &clientSecretResult, survey.WithValidator(survey.Required))
 	}
 
@@ -172,14 +275,99 @@ func InteractiveSetup(database *db.CredentialStore, clientName string, defaultPo
 		return
 	}
 
+	var assertionResult oidc.JwtBearerAssertion
+
+	if grantTypeResult == oidc.JwtBearer {
+		var assertionErr error
+
+		assertionResult, assertionErr = askForAssertion()
+
+		if assertionErr != nil {
+			log.Printf("Prompt failed %v\n", assertionErr)
+			return
+		}
+	}
+
+	var usePARResult bool
+
+	if grantTypeResult == oidc.PKCE || grantTypeResult == oidc.AuthorisationCode || grantTypeResult == oidc.Hybrid {
+		usePAR := &survey.Confirm{
+			Message: "Send the authorisation request with PAR (Pushed Authorization Requests)?",
+		}
+
+		usePARErr := survey.AskOne(usePAR, &usePARResult)
+
+		if usePARErr != nil {
+			log.Printf("Prompt failed %v\n", usePARErr)
+			return
+		}
+	}
+
+	var responseModeResult string
+
+	// Tokens from the authorisation endpoint mustn't go in the query string
+	// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#Combinations
+	if grantTypeResult == oidc.Hybrid || grantTypeResult == oidc.Implicit {
+		responseMode := &survey.Select{
+			Message: "How should the provider return the authorisation response?",
+			Options: []string{oidc.ResponseModeFragment, oidc.ResponseModeFormPost},
+			Default: oidc.ResponseModeFragment,
+		}
+
+		responseModeErr := survey.AskOne(responseMode, &responseModeResult)
+
+		if responseModeErr != nil {
+			log.Printf("Prompt failed %v\n", responseModeErr)
+			return
+		}
+	}
+
+	if grantTypeResult == oidc.PKCE || grantTypeResult == oidc.AuthorisationCode {
+		responseMode := &survey.Select{
+			Message: "How should the provider return the authorisation response?",
+			Options: []string{oidc.ResponseModeQuery, oidc.ResponseModeFormPost},
+			Default: oidc.ResponseModeQuery,
+		}
+
+		responseModeErr := survey.AskOne(responseMode, &responseModeResult)
+
+		if responseModeErr != nil {
+			log.Printf("Prompt failed %v\n", responseModeErr)
+			return
+		}
+	}
+
+	var useDPoPResult bool
+
+	// DPoP proofs are sent with token requests, and the implicit grant never makes one
+	if grantTypeResult != oidc.Implicit {
+		useDPoP := &survey.Confirm{
+			Message: "Bind tokens to a key with DPoP proof-of-possession?",
+		}
+
+		useDPoPErr := survey.AskOne(useDPoP, &useDPoPResult)
+
+		if useDPoPErr != nil {
+			log.Printf("Prompt failed %v\n", useDPoPErr)
+			return
+		}
+	}
+
+	accessTokenValidationResult, accessTokenAudienceResult, accessTokenValidationErr := askForAccessTokenValidation()
+
+	if accessTokenValidationErr != nil {
+		log.Printf("Prompt failed %v\n", accessTokenValidationErr)
+		return
+	}
+
 	// Set default scopes depending on the grant type
 	var scopeCollection []string
 
-	if grantTypeResult == oidc.PKCE {
+	if grantTypeResult == oidc.PKCE || grantTypeResult == oidc.Implicit {
 		scopeCollection = []string{"openid"}
 	}
 
-	if grantTypeResult == oidc.AuthorisationCode {
+	if grantTypeResult == oidc.AuthorisationCode || grantTypeResult == oidc.Hybrid || grantTypeResult == oidc.DeviceCode {
 		scopeCollection = []string{"openid", "offline_access"}
 	}
 
@@ -225,22 +413,51 @@ func InteractiveSetup(database *db.CredentialStore, clientName string, defaultPo
 	}
 
 	client := db.OidcClient{
-		Authority:   authorityResult,
-		Alias:       aliasResult,
-		GrantType:   grantTypeResult,
-		ClientId:    clientIdResult,
-		Scopes:      scopeCollection,
-		CreatedDate: time.Now(),
+		Authority:             authorityResult,
+		Alias:                 aliasResult,
+		GrantType:             grantTypeResult,
+		AuthMethod:            authMethodResult,
+		ClientId:              clientIdResult,
+		Assertion:             assertionResult,
+		UsePAR:                usePARResult,
+		ResponseMode:          responseModeResult,
+		UseDPoP:               useDPoPResult,
+		Scopes:                scopeCollection,
+		CreatedDate:           time.Now(),
+		AccessTokenValidation: accessTokenValidationResult,
+		AccessTokenAudience:   accessTokenAudienceResult,
 	}
 
 	var saveErr error
 
-	_, saveErr = database.SaveClientWithSecret(client, clientSecretResult)
+	if privateKeyResult != "" {
+		_, saveErr = database.SaveClientWithPrivateKey(client, privateKeyResult)
+	}
+
+	if saveErr == nil && authMethodResult != oidc.PrivateKeyJwt {
+		_, saveErr = database.SaveClientWithSecret(client, clientSecretResult)
+	}
 
 	if saveErr != nil {
 		log.Fatalf("error creating client: %v\n", saveErr)
 	}
 
+	if useCertificateResult {
+		_, saveErr = database.SetClientCertificate(client, certificateResult, certificateKeyResult)
+
+		if saveErr != nil {
+			log.Fatalf("error saving client certificate: %v\n", saveErr)
+		}
+	}
+
+	if useDPoPResult {
+		_, saveErr = database.GetDPoPKey(client.Alias)
+
+		if saveErr != nil {
+			log.Fatalf("error creating DPoP key: %v\n", saveErr)
+		}
+	}
+
 	log.Printf("✅ Saved settings for %q\n\nAuthority: %q\nClient id: %q\nGrant type: %q\nScopes: %q\n",
 		client.Alias,
 		client.Authority,
@@ -249,7 +466,7 @@ func InteractiveSetup(database *db.CredentialStore, clientName string, defaultPo
 		strings.Join(client.Scopes, ", "))
 
 	// Helpful hints for clients that need a redirect URI
-	if grantTypeResult == oidc.PKCE || grantTypeResult == oidc.AuthorisationCode {
+	if grantTypeResult == oidc.PKCE || grantTypeResult == oidc.AuthorisationCode || grantTypeResult == oidc.Hybrid || grantTypeResult == oidc.Implicit {
 		log.Printf("\n%s %s %s\n\n",
 			color.LightGreen.Sprintf("👉 Remember: make sure you've added"),
 			color.White.Sprintf(fmt.Sprintf("http://localhost:%d/callback", defaultPort)),
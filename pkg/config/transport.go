@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/spf13/cobra"
+)
+
+// The connection's transport settings
+const ProxySetting = "proxy"
+const CACertificateSetting = "ca-cert"
+const MinTLSVersionSetting = "min-tls"
+const InsecureSkipVerifySetting = "insecure-skip-verify"
+
+var transportSettings = []string{ProxySetting, CACertificateSetting, MinTLSVersionSetting, InsecureSkipVerifySetting}
+
+func ValidateTransportCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please supply a client name, e.g, `xero`")
+	}
+	if len(args) < 2 || !Contains(transportSettings, args[1]) {
+		return fmt.Errorf("please supply a setting: %s, %s, %s or %s", ProxySetting, CACertificateSetting, MinTLSVersionSetting, InsecureSkipVerifySetting)
+	}
+	if len(args) > 3 {
+		return errors.New("please supply a single value, or none to clear the setting")
+	}
+	return nil
+}
+
+// SetTransportOption changes how xoauth reaches the connection's provider. An empty value clears the setting
+func SetTransportOption(database *db.CredentialStore, clientName string, setting string, value string) {
+	allClients, clientsErr := database.GetClients()
+
+	if clientsErr != nil {
+		log.Fatal(clientsErr)
+	}
+
+	client, clientErr := database.GetClientWithoutSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+
+	var transport = client.Transport
+
+	switch setting {
+	case ProxySetting:
+		transport.Proxy = value
+	case CACertificateSetting:
+		if value != "" {
+			// The file is read on every request, so it must be found from any working directory
+			absolutePath, absErr := filepath.Abs(value)
+
+			if absErr != nil {
+				log.Fatal(absErr)
+			}
+
+			value = absolutePath
+		}
+
+		transport.CACertificateFile = value
+	case MinTLSVersionSetting:
+		transport.MinTLSVersion = value
+	case InsecureSkipVerifySetting:
+		var insecure = false
+
+		if value != "" {
+			parsed, parseErr := strconv.ParseBool(value)
+
+			if parseErr != nil {
+				log.Fatalf("%s must be true or false", InsecureSkipVerifySetting)
+			}
+
+			insecure = parsed
+		}
+
+		transport.InsecureSkipVerify = insecure
+	}
+
+	if validateErr := transport.Validate(); validateErr != nil {
+		log.Fatal(validateErr)
+	}
+
+	client.Transport = transport
+
+	_, saveErr := database.SaveClientMetadata(client)
+
+	if saveErr != nil {
+		log.Fatal(saveErr)
+	}
+
+	if value == "" {
+		log.Printf("Cleared %s for %s", setting, clientName)
+		return
+	}
+
+	log.Printf("Set %s to %s for %s", setting, value, clientName)
+}
+
+// FormatTransport describes the connection's transport settings, or is empty when it has none
+func FormatTransport(transport oidc.TransportOptions) []string {
+	var settings []string
+
+	if transport.Proxy != "" {
+		settings = append(settings, fmt.Sprintf("%s=%s", ProxySetting, transport.Proxy))
+	}
+
+	if transport.CACertificateFile != "" {
+		settings = append(settings, fmt.Sprintf("%s=%s", CACertificateSetting, transport.CACertificateFile))
+	}
+
+	if transport.MinTLSVersion != "" {
+		settings = append(settings, fmt.Sprintf("%s=%s", MinTLSVersionSetting, transport.MinTLSVersion))
+	}
+
+	if transport.InsecureSkipVerify {
+		settings = append(settings, fmt.Sprintf("%s=true", InsecureSkipVerifySetting))
+	}
+
+	return settings
+}
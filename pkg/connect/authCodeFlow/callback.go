@@ -3,24 +3,53 @@ package authCodeFlow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/dgrijalva/jwt-go/v4"
 	"github.com/gookit/color"
 )
 
-// renderAndLogError prints the error message to the browser, then shut down the web server gracefully
-func renderAndLogError(w http.ResponseWriter, cancelFunc context.CancelFunc, errorMessage string) {
-	_, streamErr := fmt.Fprintf(w, errorMessage)
+// renderAndLogError prints the error message to the browser, then shut down the web server gracefully.
+// The error is kept, so xoauth exits with its exit code
+func (interactor *CodeFlowInteractor) renderAndLogError(w http.ResponseWriter, cancelFunc context.CancelFunc, err error) {
+	interactor.callbackErr = err
 
-	log.Printf(errorMessage)
+	renderError(w, cancelFunc, ErrorViewModel{
+		Title:   "Something went wrong",
+		Message: err.Error(),
+	})
+}
+
+// renderAndLogAuthorisationError explains an error the provider redirected back with
+func (interactor *CodeFlowInteractor) renderAndLogAuthorisationError(w http.ResponseWriter, cancelFunc context.CancelFunc, authErr oidc.AuthorisationError) {
+	interactor.callbackErr = authErr
+
+	renderError(w, cancelFunc, ErrorViewModel{
+		Title:    fmt.Sprintf("The provider returned %s", authErr.Code),
+		Message:  authErr.Error(),
+		ErrorUri: authErr.Uri,
+	})
+}
+
+func renderError(w http.ResponseWriter, cancelFunc context.CancelFunc, viewModel ErrorViewModel) {
+	log.Printf("%s", color.Red.Sprintf("%s", viewModel.Message))
 
-	if streamErr != nil {
-		log.Printf("Failed to write to stream %v\n", streamErr)
+	t := template.New("error")
+	_, parseErr := t.Parse(ErrorView())
+
+	if parseErr == nil {
+		parseErr = t.Execute(w, viewModel)
+	}
+
+	if parseErr != nil {
+		log.Printf("Failed to write to stream %v\n", parseErr)
 	}
 
 	cancelFunc()
@@ -33,35 +62,128 @@ func (interactor *CodeFlowInteractor) handleOidcCallback(
 	w http.ResponseWriter,
 	r *http.Request,
 	clientName string,
-	clientId string,
-	clientSecret string,
+	clientAuth oidc.ClientAuthentication,
 	redirectUri string,
+	responseType string,
 	state string,
+	expectations oidc.IdTokenExpectations,
+	accessTokenPolicy oidc.AccessTokenPolicy,
+	authorisationDetails oidc.AuthorisationDetails,
+	resources []string,
 	codeVerifier string,
 	cancel context.CancelFunc,
 ) {
-	var authorisationResponse, err = oidc.ValidateAuthorisationResponse(r.URL, state)
+	var responseParameters = r.URL.Query()
+
+	// With form_post, the browser POSTs the response to the redirect URI
+	// https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html#FormPostResponseMode
+	if r.Method == http.MethodPost {
+		if parseErr := r.ParseForm(); parseErr != nil {
+			interactor.renderAndLogError(w, cancel, fmt.Errorf("unable to read the posted authorisation response: %v", parseErr))
+			return
+		}
+
+		responseParameters = r.PostForm
+	}
+
+	// A response in the fragment arrives without parameters, so have the browser post them back
+	if r.Method == http.MethodGet && len(responseParameters) == 0 && responseType != oidc.ResponseTypeCode {
+		t := template.New("relay")
+		_, parseErr := t.Parse(FragmentRelayView())
+
+		if parseErr == nil {
+			parseErr = t.Execute(w, nil)
+		}
+
+		if parseErr != nil {
+			interactor.renderAndLogError(w, cancel, parseErr)
+		}
+
+		return
+	}
+
+	var authorisationResponse, err = oidc.ValidateAuthorisationResponse(responseParameters, state, interactor.wellKnownConfig, responseType)
+
+	var authErr oidc.AuthorisationError
+
+	if errors.As(err, &authErr) {
+		interactor.renderAndLogAuthorisationError(w, cancel, authErr)
+		return
+	}
 
 	if err != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", err))
+		interactor.renderAndLogError(w, cancel, err)
 		return
 	}
 
 	log.Println("Received OIDC response")
 
-	var result, codeExchangeErr = oidc.ExchangeCodeForToken(interactor.wellKnownConfig.TokenEndpoint, authorisationResponse.Code, clientId, clientSecret, codeVerifier, redirectUri)
+	var frontChannelClaims jwt.MapClaims
+
+	// An ID token from the authorisation endpoint must carry hashes of the code and access token returned with it
+	// https://openid.net/specs/openid-connect-core-1_0.html#HybridIDToken
+	// https://openid.net/specs/openid-connect-core-1_0.html#ImplicitIDToken
+	if authorisationResponse.IdToken != "" {
+		var frontChannelExpectations = expectations
+		frontChannelExpectations.Code = authorisationResponse.Code
+		frontChannelExpectations.AccessToken = authorisationResponse.AccessToken
+		frontChannelExpectations.RequireCodeHash = oidc.ResponseTypeIncludes(responseType, "code")
+		frontChannelExpectations.RequireAccessTokenHash = oidc.ResponseTypeIncludes(responseType, "token")
+
+		var frontChannelErr error
+		frontChannelClaims, frontChannelErr = oidc.ValidateIdToken(authorisationResponse.IdToken, interactor.wellKnownConfig, frontChannelExpectations)
+
+		if frontChannelErr != nil {
+			interactor.renderAndLogError(w, cancel, frontChannelErr)
+			return
+		}
+	}
+
+	var result = oidc.TokenResultSet{
+		AccessToken:   authorisationResponse.AccessToken,
+		IdentityToken: authorisationResponse.IdToken,
+		TokenType:     authorisationResponse.TokenType,
+		ExpiresIn:     authorisationResponse.ExpiresIn,
+		ExpiresAt:     oidc.AbsoluteExpiry(time.Now(), authorisationResponse.ExpiresIn),
+	}
 
-	if codeExchangeErr != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", codeExchangeErr))
+	if oidc.ResponseTypeIncludes(responseType, "code") {
+		var codeExchangeErr error
+		result, codeExchangeErr = oidc.ExchangeCodeForToken(interactor.wellKnownConfig.TokenEndpoint, authorisationResponse.Code, clientAuth, codeVerifier, redirectUri, authorisationDetails, resources)
+
+		if codeExchangeErr != nil {
+			interactor.renderAndLogError(w, cancel, codeExchangeErr)
+			return
+		}
+	}
+
+	var accessTokenErr = oidc.ValidateAccessToken(result.AccessToken, interactor.wellKnownConfig, accessTokenPolicy)
+
+	if accessTokenErr != nil {
+		interactor.renderAndLogError(w, cancel, accessTokenErr)
 		return
 	}
 
 	log.Println("Validating token")
 
-	var claims, validateErr = oidc.ValidateToken(result.IdentityToken, interactor.wellKnownConfig, clientId)
+	var claims = frontChannelClaims
+
+	if oidc.ResponseTypeIncludes(responseType, "code") {
+		expectations.AccessToken = result.AccessToken
+
+		var validateErr error
+		claims, validateErr = oidc.ValidateIdToken(result.IdentityToken, interactor.wellKnownConfig, expectations)
+
+		if validateErr != nil {
+			interactor.renderAndLogError(w, cancel, validateErr)
+			return
+		}
+	}
 
-	if validateErr != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", validateErr))
+	// Both ID tokens of the hybrid flow must be about the same user
+	// https://openid.net/specs/openid-connect-core-1_0.html#HybridTokenResponse
+	if frontChannelClaims != nil && (claims["iss"] != frontChannelClaims["iss"] || claims["sub"] != frontChannelClaims["sub"]) {
+		interactor.renderAndLogError(w, cancel, errors.New("the ID token from the token endpoint has a different iss or sub to the one from the authorisation endpoint"))
 		return
 	}
 
@@ -69,7 +191,7 @@ func (interactor *CodeFlowInteractor) handleOidcCallback(
 	_, parseErr := t.Parse(TokenResultView())
 
 	if parseErr != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", parseErr))
+		interactor.renderAndLogError(w, cancel, parseErr)
 		return
 	}
 
@@ -84,7 +206,7 @@ func (interactor *CodeFlowInteractor) handleOidcCallback(
 	tplErr := t.Execute(w, viewModel)
 
 	if tplErr != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", tplErr))
+		interactor.renderAndLogError(w, cancel, tplErr)
 		return
 	}
 
@@ -102,14 +224,14 @@ func (interactor *CodeFlowInteractor) handleOidcCallback(
 	jsonData, jsonMarsallErr := json.MarshalIndent(result, "", "    ")
 
 	if jsonMarsallErr != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", jsonMarsallErr))
+		interactor.renderAndLogError(w, cancel, jsonMarsallErr)
 		return
 	}
 
 	_, finalWriteErr := fmt.Fprintln(os.Stdout, string(jsonData))
 
 	if finalWriteErr != nil {
-		renderAndLogError(w, cancel, fmt.Sprintf("%v", finalWriteErr))
+		interactor.renderAndLogError(w, cancel, finalWriteErr)
 		return
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/XeroAPI/xoauth/pkg/db"
 	"github.com/XeroAPI/xoauth/pkg/interop"
@@ -16,6 +17,8 @@ type CodeFlowInteractor struct {
 	wellKnownConfig oidc.WellKnownConfiguration
 	database        *db.CredentialStore
 	operatingSystem string
+	// Set when the callback fails, so xoauth exits with the matching exit code
+	callbackErr error
 }
 
 func NewCodeFlowInteractor(wellKnownConfig oidc.WellKnownConfiguration, database *db.CredentialStore, operatingSystem string) CodeFlowInteractor {
@@ -26,21 +29,45 @@ func NewCodeFlowInteractor(wellKnownConfig oidc.WellKnownConfiguration, database
 	}
 }
 
-func (interactor *CodeFlowInteractor) Request(client db.OidcClient, dryRun bool, localHostPort int) {
-	interactor.initRequest(client, "", "", dryRun, localHostPort)
+func (interactor *CodeFlowInteractor) Request(client db.OidcClient, dryRun bool, localHostPort int, maxAge int) {
+	interactor.initRequest(client, oidc.ResponseTypeCode, "", "", dryRun, localHostPort, maxAge)
 }
 
-func (interactor *CodeFlowInteractor) RequestWithProofOfKeyExchange(client db.OidcClient, dryRun bool, localHostPort int) {
+func (interactor *CodeFlowInteractor) RequestWithProofOfKeyExchange(client db.OidcClient, dryRun bool, localHostPort int, maxAge int) {
 	var verifierSet, verifierErr = oidc.GenerateCodeVerifier()
 
 	if verifierErr != nil {
 		log.Fatalln(verifierErr)
 	}
 
-	interactor.initRequest(client, verifierSet.CodeVerifier, verifierSet.CodeChallenge, dryRun, localHostPort)
+	interactor.initRequest(client, oidc.ResponseTypeCode, verifierSet.CodeVerifier, verifierSet.CodeChallenge, dryRun, localHostPort, maxAge)
 }
 
-func (interactor *CodeFlowInteractor) initRequest(client db.OidcClient, codeVerifier string, codeChallenge string, dryRun bool, localHostPort int) {
+// RequestHybrid returns the code and an ID token from the authorisation endpoint, then exchanges the code
+// https://openid.net/specs/openid-connect-core-1_0.html#HybridFlowAuth
+func (interactor *CodeFlowInteractor) RequestHybrid(client db.OidcClient, dryRun bool, localHostPort int, maxAge int) {
+	var verifierSet, verifierErr = oidc.GenerateCodeVerifier()
+
+	if verifierErr != nil {
+		log.Fatalln(verifierErr)
+	}
+
+	interactor.initRequest(client, oidc.ResponseTypeHybrid, verifierSet.CodeVerifier, verifierSet.CodeChallenge, dryRun, localHostPort, maxAge)
+}
+
+// RequestImplicit returns the tokens straight from the authorisation endpoint. It's only here to test legacy clients
+// https://openid.net/specs/openid-connect-core-1_0.html#ImplicitFlowAuth
+func (interactor *CodeFlowInteractor) RequestImplicit(client db.OidcClient, dryRun bool, localHostPort int, maxAge int) {
+	log.Printf("%s", color.Yellow.Sprintf("The implicit flow is deprecated, as tokens are exposed in the browser. Only use it to test legacy clients"))
+
+	interactor.initRequest(client, oidc.ResponseTypeImplicit, "", "", dryRun, localHostPort, maxAge)
+}
+
+func (interactor *CodeFlowInteractor) initRequest(client db.OidcClient, responseType string, codeVerifier string, codeChallenge string, dryRun bool, localHostPort int, maxAge int) {
+	if interactor.wellKnownConfig.AuthorisationEndpoint == "" {
+		log.Fatalln("no authorisation endpoint in OIDC metadata")
+	}
+
 	redirectUri := fmt.Sprintf("http://localhost:%d/callback", localHostPort)
 	state, stateErr := oidc.GenerateRandomStringURLSafe(24)
 
@@ -48,15 +75,83 @@ func (interactor *CodeFlowInteractor) initRequest(client db.OidcClient, codeVeri
 		panic("failed to generate random state. Check that your OS has a crypto implementation available")
 	}
 
-	authorisationUrl := oidc.BuildCodeAuthorisationRequest(
-		interactor.wellKnownConfig,
+	nonce, nonceErr := oidc.GenerateRandomStringURLSafe(24)
+
+	if nonceErr != nil {
+		panic("failed to generate random nonce. Check that your OS has a crypto implementation available")
+	}
+
+	authorisationParameters := oidc.AuthorisationParameters(
+		responseType,
 		client.ClientId,
 		redirectUri,
 		client.Scopes,
 		state,
+		nonce,
 		codeChallenge,
+		client.ResponseMode,
 	)
 
+	if maxAge != oidc.NoMaxAge {
+		authorisationParameters.Set("max_age", strconv.Itoa(maxAge))
+	}
+
+	// The access tokens can then be restricted to each of these resources
+	// https://datatracker.ietf.org/doc/html/rfc8707#section-2.1
+	for _, resource := range client.Resources {
+		authorisationParameters.Add("resource", resource)
+	}
+
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-3
+	if len(client.AuthorisationDetails) > 0 {
+		client.AuthorisationDetails.WarnAboutUnsupportedTypes(interactor.wellKnownConfig)
+
+		authorisationDetails, encodeErr := client.AuthorisationDetails.Encode()
+
+		if encodeErr != nil {
+			log.Fatalln(encodeErr)
+		}
+
+		authorisationParameters.Set("authorization_details", authorisationDetails)
+	}
+
+	// Extra parameters replace the ones xoauth sends, so they can be used to test how the provider handles them
+	for key, value := range client.AuthorisationParameters {
+		authorisationParameters.Set(key, value)
+	}
+
+	// The response is checked against what was actually sent, so overriding these doesn't skip their checks
+	state = authorisationParameters.Get("state")
+	nonce = authorisationParameters.Get("nonce")
+
+	if sentMaxAge, overridden := client.AuthorisationParameters["max_age"]; overridden {
+		parsedMaxAge, parseErr := strconv.Atoi(sentMaxAge)
+
+		if parseErr != nil {
+			log.Printf("%s", color.Yellow.Sprintf("max_age %q isn't a number of seconds, so the ID token's auth_time won't be checked", sentMaxAge))
+			parsedMaxAge = oidc.NoMaxAge
+		}
+
+		maxAge = parsedMaxAge
+	}
+
+	authorisationUrl := oidc.BuildAuthorisationUrl(interactor.wellKnownConfig, authorisationParameters)
+
+	// Push the parameters over the back channel, so only a reference to them goes through the browser
+	if client.UsePAR || interactor.wellKnownConfig.RequirePushedAuthorisationRequests {
+		pushedRequest, pushErr := oidc.PushAuthorisationRequest(
+			interactor.wellKnownConfig.PushedAuthorisationRequestEndpoint,
+			client.Authentication(),
+			authorisationParameters,
+		)
+
+		if pushErr != nil {
+			oidc.ExitWithError(pushErr)
+		}
+
+		authorisationUrl = oidc.BuildPushedAuthorisationRequest(interactor.wellKnownConfig, client.ClientId, pushedRequest.RequestUri)
+	}
+
 	if dryRun {
 		log.Printf("%s\n%s\n",
 			color.FgWhite.Sprint("Dry run, printing the authorisation request URL"),
@@ -66,18 +161,28 @@ func (interactor *CodeFlowInteractor) initRequest(client db.OidcClient, codeVeri
 
 	m := http.NewServeMux()
 	s := http.Server{Addr: fmt.Sprintf(":%d", localHostPort), Handler: m}
-	ctx, cancel := context.WithCancel(context.Background())
+	// Ctrl-C stops waiting for the browser too
+	ctx, cancel := context.WithCancel(oidc.RequestContext())
 
 	defer cancel()
 
 	// Open a web server to receive the redirect
-	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	m.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
 		interactor.handleOidcCallback(w, r,
 			client.Alias,
-			client.ClientId,
-			client.ClientSecret,
+			client.Authentication(),
 			redirectUri,
+			responseType,
 			state,
+			oidc.IdTokenExpectations{
+				ClientId:          client.ClientId,
+				Nonce:             nonce,
+				MaxAge:            maxAge,
+				AllowedAlgorithms: client.AllowedAlgorithms,
+			},
+			client.AccessTokenPolicy(),
+			client.AuthorisationDetails,
+			client.Resources,
 			codeVerifier,
 			cancel,
 		)
@@ -107,5 +212,13 @@ func (interactor *CodeFlowInteractor) initRequest(client db.OidcClient, codeVeri
 		} else {
 			log.Println("")
 		}
+
+		if oidc.RequestContext().Err() != nil {
+			log.Fatalln("Cancelled before the browser returned")
+		}
+
+		if interactor.callbackErr != nil {
+			oidc.ExitWithError(interactor.callbackErr)
+		}
 	}
 }
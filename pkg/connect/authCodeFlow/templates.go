@@ -123,3 +123,63 @@ func TokenResultView() string {
 </html>
 `
 }
+
+type ErrorViewModel struct {
+	Title string
+	Message string
+	ErrorUri string
+}
+
+func ErrorView() string {
+	return `
+<!doctype html>
+<html>
+	<head>
+		<title>XOAuth</title>
+		<style>
+			body {
+				font-family: sans-serif;
+				margin: 3em;
+			}
+		</style>
+	</head>
+	<body>
+		<h3>❌ {{.Title}}</h3>
+		<p>{{.Message}}</p>
+		{{ if .ErrorUri }}
+		<p><a href="{{.ErrorUri}}">More about this error</a></p>
+		{{ end }}
+		<p>Check the terminal for details. You can close this window now.</p>
+	</body>
+</html>
+`
+}
+
+// FragmentRelayView posts a response returned in the URL fragment back to the callback, as the fragment never reaches the server
+// https://openid.net/specs/openid-connect-core-1_0.html#FragmentNotes
+func FragmentRelayView() string {
+	return `
+<!doctype html>
+<html>
+	<head>
+		<title>XOAuth</title>
+	</head>
+	<body>
+		<form method="post" action="/callback"></form>
+		<script>
+			var form = document.forms[0];
+			var parameters = new URLSearchParams(window.location.hash.substring(1));
+			parameters.forEach(function (value, name) {
+				var input = document.createElement("input");
+				input.type = "hidden";
+				input.name = name;
+				input.value = value;
+				form.appendChild(input);
+			});
+			form.submit();
+		</script>
+		<noscript>JavaScript is needed to read the response from the URL fragment.</noscript>
+	</body>
+</html>
+`
+}
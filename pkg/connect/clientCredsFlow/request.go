@@ -29,18 +29,18 @@ func NewClientCredsFlow(wellKnownConfig oidc.WellKnownConfiguration, database *d
 func (interactor *ClientCredsFlowInteractor) Request(client db.OidcClient, dryRun bool) {
 	var scopes = strings.Join(client.Scopes, " ")
 
-	var tokenResult, tokenErr = oidc.RequestWithClientCredentials(interactor.wellKnownConfig.TokenEndpoint, client.ClientId, client.ClientSecret, scopes)
+	client.AuthorisationDetails.WarnAboutUnsupportedTypes(interactor.wellKnownConfig)
+
+	var tokenResult, tokenErr = oidc.RequestWithClientCredentials(interactor.wellKnownConfig.TokenEndpoint, client.Authentication(), scopes, client.AuthorisationDetails, client.Resources)
 
 	if tokenErr != nil {
-		log.Fatalln(tokenErr)
+		oidc.ExitWithError(tokenErr)
 	}
 
-	log.Println("Validating access token")
-
-	var _, validateErr = oidc.ValidateToken(tokenResult.AccessToken, interactor.wellKnownConfig, client.ClientId)
+	var validateErr = oidc.ValidateAccessToken(tokenResult.AccessToken, interactor.wellKnownConfig, client.AccessTokenPolicy())
 
 	if validateErr != nil {
-		log.Fatalln(validateErr)
+		oidc.ExitWithError(validateErr)
 	}
 
 	jsonData, jsonErr := json.MarshalIndent(tokenResult, "", "    ")
@@ -48,7 +48,10 @@ func (interactor *ClientCredsFlowInteractor) Request(client db.OidcClient, dryRu
 	log.Print("Storing tokens in local keychain")
 	_, tokenSaveErr := interactor.database.SaveTokens(client.Alias, oidc.TokenResultSet{
 		AccessToken: tokenResult.AccessToken,
+		TokenType:   tokenResult.TokenType,
 		ExpiresAt:   tokenResult.ExpiresAt,
+		// Saved so it's clear what the token is good for
+		AuthorisationDetails: tokenResult.AuthorisationDetails,
 	})
 
 	// Can fail with warning
@@ -5,11 +5,14 @@ import (
 
 	"github.com/XeroAPI/xoauth/pkg/connect/authCodeFlow"
 	"github.com/XeroAPI/xoauth/pkg/connect/clientCredsFlow"
+	"github.com/XeroAPI/xoauth/pkg/connect/deviceFlow"
+	"github.com/XeroAPI/xoauth/pkg/connect/jwtBearerFlow"
+	"github.com/XeroAPI/xoauth/pkg/connect/logoutFlow"
 	"github.com/XeroAPI/xoauth/pkg/db"
 	"github.com/XeroAPI/xoauth/pkg/oidc"
 )
 
-func Authorise(database *db.CredentialStore, name string, operatingSystem string, dryRun bool, localHostPort int) {
+func Authorise(database *db.CredentialStore, name string, operatingSystem string, dryRun bool, localHostPort int, maxAge int, extraParameters map[string]string, authorisationDetails oidc.AuthorisationDetails) {
 	allClients, dbErr := database.GetClients()
 
 	if dbErr != nil {
@@ -32,23 +35,89 @@ func Authorise(database *db.CredentialStore, name string, operatingSystem string
 		panic(clientErr)
 	}
 
-	var wellKnownConfig, wellKnownErr = oidc.GetMetadata(client.Authority)
+	var wellKnownConfig, wellKnownErr = client.Metadata()
 
 	if wellKnownErr != nil {
-		panic(wellKnownErr)
+		oidc.ExitWithError(wellKnownErr)
+	}
+
+	// Parameters given on the command line replace the connection's saved ones for this request only
+	if len(extraParameters) > 0 {
+		var authorisationParameters = map[string]string{}
+
+		for key, value := range client.AuthorisationParameters {
+			authorisationParameters[key] = value
+		}
+
+		for key, value := range extraParameters {
+			authorisationParameters[key] = value
+		}
+
+		client.AuthorisationParameters = authorisationParameters
+	}
+
+	if len(authorisationDetails) > 0 {
+		client.AuthorisationDetails = authorisationDetails
 	}
 
 	switch grantType := client.GrantType; grantType {
 	case oidc.PKCE:
 		interactor := authCodeFlow.NewCodeFlowInteractor(wellKnownConfig, database, operatingSystem)
-		interactor.RequestWithProofOfKeyExchange(client, dryRun, localHostPort)
+		interactor.RequestWithProofOfKeyExchange(client, dryRun, localHostPort, maxAge)
 	case oidc.AuthorisationCode:
 		interactor := authCodeFlow.NewCodeFlowInteractor(wellKnownConfig, database, operatingSystem)
-		interactor.Request(client, dryRun, localHostPort)
+		interactor.Request(client, dryRun, localHostPort, maxAge)
+	case oidc.Hybrid:
+		interactor := authCodeFlow.NewCodeFlowInteractor(wellKnownConfig, database, operatingSystem)
+		interactor.RequestHybrid(client, dryRun, localHostPort, maxAge)
+	case oidc.Implicit:
+		interactor := authCodeFlow.NewCodeFlowInteractor(wellKnownConfig, database, operatingSystem)
+		interactor.RequestImplicit(client, dryRun, localHostPort, maxAge)
 	case oidc.ClientCredentials:
 		interactor := clientCredsFlow.NewClientCredsFlow(wellKnownConfig, database, operatingSystem)
 		interactor.Request(client, dryRun)
+	case oidc.DeviceCode:
+		interactor := deviceFlow.NewDeviceFlowInteractor(wellKnownConfig, database, operatingSystem)
+		interactor.Request(client, dryRun)
+	case oidc.JwtBearer:
+		interactor := jwtBearerFlow.NewJwtBearerFlowInteractor(wellKnownConfig, database, operatingSystem)
+		interactor.Request(client, dryRun)
 	default:
 		log.Fatal("Unsupported grant type")
 	}
 }
+
+// Logout signs the user out at the provider, and clears the connection's saved tokens
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html
+func Logout(database *db.CredentialStore, name string, operatingSystem string, dryRun bool, localHostPort int) {
+	allClients, dbErr := database.GetClients()
+
+	if dbErr != nil {
+		log.Fatalln(dbErr)
+	}
+
+	var clientExists, existsErr = database.ClientExists(name)
+
+	if existsErr != nil {
+		log.Fatalln(existsErr)
+	}
+
+	if !clientExists {
+		log.Fatalf("The client %q doesn't exist. Create it using `xoauth setup`.", name)
+	}
+
+	var client, clientErr = database.GetClientWithoutSecret(allClients, name)
+
+	if clientErr != nil {
+		log.Fatalln(clientErr)
+	}
+
+	var wellKnownConfig, wellKnownErr = oidc.GetMetadata(client.Authority, client.Transport)
+
+	if wellKnownErr != nil {
+		oidc.ExitWithError(wellKnownErr)
+	}
+
+	interactor := logoutFlow.NewLogoutInteractor(wellKnownConfig, database, operatingSystem)
+	interactor.Request(client, dryRun, localHostPort)
+}
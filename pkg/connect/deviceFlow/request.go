@@ -0,0 +1,121 @@
+package deviceFlow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/gookit/color"
+)
+
+type DeviceFlowInteractor struct {
+	wellKnownConfig oidc.WellKnownConfiguration
+	database        *db.CredentialStore
+	operatingSystem string
+}
+
+func NewDeviceFlowInteractor(wellKnownConfig oidc.WellKnownConfiguration, database *db.CredentialStore, operatingSystem string) DeviceFlowInteractor {
+	return DeviceFlowInteractor{
+		wellKnownConfig: wellKnownConfig,
+		database:        database,
+		operatingSystem: operatingSystem,
+	}
+}
+
+func (interactor *DeviceFlowInteractor) Request(client db.OidcClient, dryRun bool) {
+	var scopes = strings.Join(client.Scopes, " ")
+
+	if dryRun {
+		log.Printf("%s\n%s\n",
+			color.FgWhite.Sprint("Dry run, printing the device authorisation endpoint"),
+			color.FgYellow.Sprint(interactor.wellKnownConfig.DeviceAuthorisationEndpoint))
+		return
+	}
+
+	var authorisation, authorisationErr = oidc.RequestDeviceAuthorisation(
+		interactor.wellKnownConfig.DeviceAuthorisationEndpoint,
+		client.Authentication(),
+		scopes,
+		client.Resources,
+		client.AuthorisationParameters,
+	)
+
+	if authorisationErr != nil {
+		oidc.ExitWithError(authorisationErr)
+	}
+
+	// Print the prompt on stderr, so stdout is left for the token set
+	log.Printf("\n%s %s\n%s %s\n",
+		color.LightGreen.Sprintf("👉 Visit"),
+		color.White.Sprint(authorisation.VerificationUri),
+		color.LightGreen.Sprintf("   and enter the code"),
+		color.Yellow.Sprint(authorisation.UserCode),
+	)
+
+	if authorisation.VerificationUriComplete != "" {
+		log.Printf("%s %s\n",
+			color.LightGreen.Sprintf("   or open"),
+			color.White.Sprint(authorisation.VerificationUriComplete),
+		)
+	}
+
+	log.Println("")
+
+	var result, tokenErr = oidc.PollForDeviceToken(
+		interactor.wellKnownConfig.TokenEndpoint,
+		client.Authentication(),
+		authorisation,
+	)
+
+	if tokenErr != nil {
+		oidc.ExitWithError(tokenErr)
+	}
+
+	var accessTokenErr = oidc.ValidateAccessToken(result.AccessToken, interactor.wellKnownConfig, client.AccessTokenPolicy())
+
+	if accessTokenErr != nil {
+		oidc.ExitWithError(accessTokenErr)
+	}
+
+	if result.IdentityToken != "" {
+		log.Println("Validating token")
+
+		var _, validateErr = oidc.ValidateIdToken(result.IdentityToken, interactor.wellKnownConfig, oidc.IdTokenExpectations{
+			ClientId:          client.ClientId,
+			MaxAge:            oidc.NoMaxAge,
+			AccessToken:       result.AccessToken,
+			AllowedAlgorithms: client.AllowedAlgorithms,
+		})
+
+		if validateErr != nil {
+			oidc.ExitWithError(validateErr)
+		}
+	}
+
+	log.Print("Storing tokens in local keychain")
+	_, tokenSaveErr := interactor.database.SaveTokens(client.Alias, result)
+
+	// Can fail with warning
+	if tokenSaveErr != nil {
+		log.Printf("%s: %v",
+			color.Yellow.Sprintf("failed to save tokens to keychain"),
+			tokenSaveErr,
+		)
+	}
+
+	jsonData, jsonErr := json.MarshalIndent(result, "", "    ")
+
+	if jsonErr != nil {
+		log.Fatalln(jsonErr)
+	}
+
+	_, finalWriteErr := fmt.Fprintln(os.Stdout, string(jsonData))
+
+	if finalWriteErr != nil {
+		log.Fatalln(finalWriteErr)
+	}
+}
@@ -0,0 +1,88 @@
+package jwtBearerFlow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/gookit/color"
+)
+
+type JwtBearerFlowInteractor struct {
+	wellKnownConfig oidc.WellKnownConfiguration
+	database        *db.CredentialStore
+	operatingSystem string
+}
+
+func NewJwtBearerFlowInteractor(wellKnownConfig oidc.WellKnownConfiguration, database *db.CredentialStore, operatingSystem string) JwtBearerFlowInteractor {
+	return JwtBearerFlowInteractor{
+		wellKnownConfig: wellKnownConfig,
+		database:        database,
+		operatingSystem: operatingSystem,
+	}
+}
+
+func (interactor *JwtBearerFlowInteractor) Request(client db.OidcClient, dryRun bool) {
+	var scopes = strings.Join(client.Scopes, " ")
+
+	if dryRun {
+		assertion, assertionErr := oidc.BuildJwtBearerAssertion(client.Assertion,
+			client.ClientId,
+			interactor.wellKnownConfig.TokenEndpoint,
+			client.PrivateKey,
+		)
+
+		if assertionErr != nil {
+			log.Fatalln(assertionErr)
+		}
+
+		log.Printf("%s\n%s\n",
+			color.FgWhite.Sprint("Dry run, printing the assertion"),
+			color.FgYellow.Sprint(assertion))
+		return
+	}
+
+	var tokenResult, tokenErr = oidc.RequestWithJwtBearer(interactor.wellKnownConfig.TokenEndpoint,
+		client.Authentication(),
+		client.Assertion,
+		scopes,
+		client.Resources,
+	)
+
+	if tokenErr != nil {
+		oidc.ExitWithError(tokenErr)
+	}
+
+	var accessTokenErr = oidc.ValidateAccessToken(tokenResult.AccessToken, interactor.wellKnownConfig, client.AccessTokenPolicy())
+
+	if accessTokenErr != nil {
+		oidc.ExitWithError(accessTokenErr)
+	}
+
+	log.Print("Storing tokens in local keychain")
+	_, tokenSaveErr := interactor.database.SaveTokens(client.Alias, tokenResult)
+
+	// Can fail with warning
+	if tokenSaveErr != nil {
+		log.Printf("%s: %v",
+			color.Yellow.Sprintf("failed to save tokens to keychain"),
+			tokenSaveErr,
+		)
+	}
+
+	jsonData, jsonErr := json.MarshalIndent(tokenResult, "", "    ")
+
+	if jsonErr != nil {
+		log.Fatalln(jsonErr)
+	}
+
+	_, finalWriteErr := fmt.Fprintln(os.Stdout, string(jsonData))
+
+	if finalWriteErr != nil {
+		log.Fatalln(finalWriteErr)
+	}
+}
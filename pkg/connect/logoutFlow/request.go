@@ -0,0 +1,159 @@
+package logoutFlow
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/XeroAPI/xoauth/pkg/connect/authCodeFlow"
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/interop"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/gookit/color"
+)
+
+type LogoutInteractor struct {
+	wellKnownConfig oidc.WellKnownConfiguration
+	database        *db.CredentialStore
+	operatingSystem string
+	// Set when the logout response can't be trusted or the tokens can't be cleared, so xoauth exits with an error
+	logoutErr error
+}
+
+func NewLogoutInteractor(wellKnownConfig oidc.WellKnownConfiguration, database *db.CredentialStore, operatingSystem string) LogoutInteractor {
+	return LogoutInteractor{
+		wellKnownConfig: wellKnownConfig,
+		database:        database,
+		operatingSystem: operatingSystem,
+	}
+}
+
+// Request signs the user out at the provider, waits for the browser to come back, then forgets the saved tokens
+func (interactor *LogoutInteractor) Request(client db.OidcClient, dryRun bool, localHostPort int) {
+	tokenSet, tokenErr := interactor.database.GetTokens(client.Alias)
+
+	if tokenErr != nil {
+		log.Fatalln(tokenErr)
+	}
+
+	if tokenSet.IdentityToken == "" {
+		log.Println("There's no saved ID token, so the provider may ask which account to sign out of")
+	}
+
+	redirectUri := fmt.Sprintf("http://localhost:%d/logout", localHostPort)
+	state, stateErr := oidc.GenerateRandomStringURLSafe(24)
+
+	if stateErr != nil {
+		panic("failed to generate random state. Check that your OS has a crypto implementation available")
+	}
+
+	logoutUrl, logoutErr := oidc.BuildEndSessionRequest(interactor.wellKnownConfig,
+		client.ClientId,
+		tokenSet.IdentityToken,
+		redirectUri,
+		state,
+	)
+
+	if logoutErr != nil {
+		log.Fatalln(logoutErr)
+	}
+
+	if dryRun {
+		log.Printf("%s\n%s\n",
+			color.FgWhite.Sprint("Dry run, printing the logout request URL"),
+			color.FgYellow.Sprint(logoutUrl))
+		return
+	}
+
+	m := http.NewServeMux()
+	s := http.Server{Addr: fmt.Sprintf(":%d", localHostPort), Handler: m}
+	// Ctrl-C stops waiting for the browser too
+	ctx, cancel := context.WithCancel(oidc.RequestContext())
+
+	defer cancel()
+
+	// Open a web server to receive the redirect
+	m.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		interactor.handleLogoutCallback(w, r, client.Alias, state, cancel)
+	})
+
+	log.Printf("%s", color.FgYellow.Sprintf("Opening browser window"))
+
+	openErr := interop.OpenBrowser(interactor.operatingSystem, logoutUrl)
+
+	if openErr != nil {
+		log.Fatalf("failed to open browser window %v", openErr)
+	}
+
+	go func() {
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Shutdown the server when the context is canceled
+		err := s.Shutdown(ctx)
+
+		if err != nil && err != context.Canceled {
+			log.Fatalln(err)
+		} else {
+			log.Println("")
+		}
+
+		if oidc.RequestContext().Err() != nil {
+			log.Fatalln("Cancelled before the browser returned")
+		}
+
+		if interactor.logoutErr != nil {
+			log.Fatalln(interactor.logoutErr)
+		}
+	}
+}
+
+// renderError explains what went wrong in the browser, with the same page as the authorisation callback
+func renderError(w http.ResponseWriter, title string, err error) {
+	t := template.New("error")
+	_, parseErr := t.Parse(authCodeFlow.ErrorView())
+
+	if parseErr == nil {
+		parseErr = t.Execute(w, authCodeFlow.ErrorViewModel{
+			Title:   title,
+			Message: err.Error(),
+		})
+	}
+
+	if parseErr != nil {
+		log.Printf("Failed to write to stream %v\n", parseErr)
+	}
+}
+
+// handleLogoutCallback waits for the provider to redirect back after signing the user out.
+// The saved tokens are only cleared once the redirect is known to be a response to our request
+func (interactor *LogoutInteractor) handleLogoutCallback(w http.ResponseWriter, r *http.Request, clientName string, state string, cancel context.CancelFunc) {
+	defer cancel()
+
+	validateErr := oidc.ValidateLogoutResponse(r.URL, state)
+
+	if validateErr != nil {
+		interactor.logoutErr = validateErr
+		renderError(w, "The logout response couldn't be trusted", validateErr)
+		return
+	}
+
+	log.Println("Signed out at the provider, clearing saved tokens")
+
+	deleteErr := interactor.database.DeleteTokens(clientName)
+
+	if deleteErr != nil {
+		interactor.logoutErr = fmt.Errorf("signed out at the provider, but unable to clear the saved tokens: %v", deleteErr)
+		renderError(w, "Unable to clear the saved tokens", interactor.logoutErr)
+		return
+	}
+
+	fmt.Fprint(w, "Signed out. You can close this window.")
+	log.Printf("%s", color.FgGreen.Sprintf("Signed out of %q", clientName))
+}
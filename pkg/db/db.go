@@ -17,15 +17,132 @@ import (
 
 const ConfigDirPath = ".xoauth"
 const ConfigFileName = "xoauth.json"
+const CacheDirName = "cache"
 
 type OidcClient struct {
 	Authority    string
 	Alias        string
 	GrantType    string
+	AuthMethod   string
 	ClientId     string
 	ClientSecret string
-	CreatedDate  time.Time
-	Scopes       []string
+	// The PEM encoded private key lives in the keychain, never in the config file
+	PrivateKey string `json:"-"`
+	// A PEM encoded certificate for mutual TLS. Its private key lives in the keychain
+	ClientCertificate    string
+	ClientCertificateKey string `json:"-"`
+	// Send the authorisation request parameters over the back channel with PAR
+	UsePAR bool
+	// How the provider returns the authorisation response: query, or form_post. Defaults to query
+	ResponseMode string
+	// Extra parameters, such as prompt or a provider's audience, for the authorisation request and for token requests
+	AuthorisationParameters map[string]string
+	TokenParameters         map[string]string
+	// Structured permissions to request, instead of or as well as scopes
+	AuthorisationDetails oidc.AuthorisationDetails
+	// The APIs the connection's tokens are for. Each gets its own audience-restricted access token
+	Resources []string
+	// A proxy, extra CA certificates and TLS options for reaching the provider
+	Transport oidc.TransportOptions
+	// How to build the assertion for the jwt_bearer grant
+	Assertion oidc.JwtBearerAssertion
+	// Bind tokens to a per-connection key with DPoP proofs. The key lives in the keychain
+	UseDPoP bool
+	DPoPKey string `json:"-"`
+	// The algorithms tokens may be signed with. When empty, those the provider advertises
+	AllowedAlgorithms []string
+	// How access tokens are validated: none, jwt or introspect. The audience is checked by the jwt policy
+	AccessTokenValidation string
+	AccessTokenAudience   string
+	CreatedDate           time.Time
+	Scopes                []string
+}
+
+// Authentication describes how the client authenticates itself at the provider's endpoints
+func (client OidcClient) Authentication() oidc.ClientAuthentication {
+	var method = client.AuthMethod
+
+	if method == "" {
+		method = oidc.ClientSecretBasic
+	}
+
+	return oidc.ClientAuthentication{
+		Method:          method,
+		ClientId:        client.ClientId,
+		ClientSecret:    client.ClientSecret,
+		PrivateKey:      client.PrivateKey,
+		Certificate:     client.ClientCertificate,
+		CertificateKey:  client.ClientCertificateKey,
+		DPoPKey:         client.DPoPKey,
+		TokenParameters: client.TokenParameters,
+		Transport:       client.Transport,
+		// Discovery checks that the provider's issuer is the authority
+		Issuer: client.Authority,
+	}
+}
+
+// Metadata fetches the provider's metadata, with its mTLS endpoint aliases in place of the regular endpoints
+// when the client authenticates with a certificate
+// https://tools.ietf.org/html/rfc8705#section-5
+func (client OidcClient) Metadata() (oidc.WellKnownConfiguration, error) {
+	metadata, metadataErr := oidc.GetMetadata(client.Authority, client.Transport)
+
+	if metadataErr != nil {
+		return metadata, metadataErr
+	}
+
+	if client.Authentication().UsesMutualTLS() {
+		metadata = metadata.WithMutualTLSEndpoints()
+	}
+
+	return metadata, nil
+}
+
+// AccessTokenValidationPolicy is how the connection's access tokens are validated. Client credentials
+// connections saved before the policy could be chosen always had their access tokens' signatures checked,
+// so they keep doing only that, rather than silently stopping or failing the stricter jwt checks
+func (client OidcClient) AccessTokenValidationPolicy() string {
+	if client.AccessTokenValidation == "" && client.GrantType == oidc.ClientCredentials {
+		return oidc.AccessTokenValidationSignature
+	}
+
+	if client.AccessTokenValidation == "" {
+		return oidc.AccessTokenValidationNone
+	}
+
+	return client.AccessTokenValidation
+}
+
+// AccessTokenPolicy describes how the connection's access tokens are validated
+func (client OidcClient) AccessTokenPolicy() oidc.AccessTokenPolicy {
+	return oidc.AccessTokenPolicy{
+		Validation:        client.AccessTokenValidationPolicy(),
+		ClientAuth:        client.Authentication(),
+		Scopes:            client.Scopes,
+		Audience:          client.AccessTokenAudience,
+		AllowedAlgorithms: client.AllowedAlgorithms,
+	}
+}
+
+// Device code and JWT bearer clients may be public clients, without a secret
+func secretIsOptional(client OidcClient) bool {
+	return client.GrantType == oidc.DeviceCode || client.GrantType == oidc.JwtBearer
+}
+
+func privateKeyName(clientName string) string {
+	return fmt.Sprintf("%s:private_key", clientName)
+}
+
+func certificateKeyName(clientName string) string {
+	return fmt.Sprintf("%s:tls_key", clientName)
+}
+
+func dpopKeyName(clientName string) string {
+	return fmt.Sprintf("%s:dpop_key", clientName)
+}
+
+func resourceTokensName(clientName string, resource string) string {
+	return fmt.Sprintf("%s:resource:%s", clientName, resource)
 }
 
 type CredentialStore struct {
@@ -92,6 +209,11 @@ func (store *CredentialStore) getDbFile() string {
 	return path
 }
 
+// GetCacheDir is where provider metadata and signing keys are cached
+func (store *CredentialStore) GetCacheDir() string {
+	return filepath.Join(filepath.Dir(store.getDbFile()), CacheDirName)
+}
+
 func (store *CredentialStore) GetClients() (map[string]OidcClient, error) {
 	var clients = make(map[string]OidcClient)
 	var file = store.getDbFile()
@@ -120,13 +242,56 @@ func (store *CredentialStore) GetClientWithSecret(allClients map[string]OidcClie
 
 	client = allClients[name]
 
-	if client.GrantType == oidc.PKCE {
+	if client.ClientCertificate != "" {
+		certificateKey, keyringErr := store.KeyRingService.Get(certificateKeyName(client.Alias))
+
+		if keyringErr != nil {
+			return client, keyringErr
+		}
+
+		client.ClientCertificateKey = certificateKey
+	}
+
+	if client.UseDPoP {
+		dpopKey, dpopErr := store.GetDPoPKey(client.Alias)
+
+		if dpopErr != nil {
+			return client, dpopErr
+		}
+
+		client.DPoPKey = dpopKey
+	}
+
+	// The private key signs client assertions for private_key_jwt, and the grant's assertion for jwt_bearer
+	if client.AuthMethod == oidc.PrivateKeyJwt || client.GrantType == oidc.JwtBearer {
+		privateKey, keyringErr := store.KeyRingService.Get(privateKeyName(client.Alias))
+
+		if keyringErr != nil {
+			return client, keyringErr
+		}
+
+		client.PrivateKey = privateKey
+	}
+
+	// The client certificate is the only credential for tls_client_auth
+	if client.AuthMethod == oidc.TlsClientAuth {
+		client.ClientSecret = ""
+		return client, nil
+	}
+
+	if client.GrantType == oidc.PKCE || client.GrantType == oidc.Implicit || client.AuthMethod == oidc.PrivateKeyJwt {
 		client.ClientSecret = ""
 		return client, nil
 	}
 
 	secret, keyringErr := store.KeyRingService.Get(client.Alias)
 
+	// Some clients can be public clients, so a missing secret isn't an error
+	if keyringErr != nil && secretIsOptional(client) {
+		client.ClientSecret = ""
+		return client, nil
+	}
+
 	if keyringErr != nil {
 		return client, keyringErr
 	}
@@ -199,6 +364,76 @@ func (store *CredentialStore) SetClientSecret(clientName string, secret string)
 	return true, nil
 }
 
+func (store *CredentialStore) SetClientPrivateKey(clientName string, privateKey string) (bool, error) {
+	_, parseErr := oidc.ParsePrivateKey(privateKey)
+
+	if parseErr != nil {
+		return false, parseErr
+	}
+
+	keyringErr := store.KeyRingService.Set(privateKeyName(clientName), privateKey)
+
+	if keyringErr != nil {
+		return false, keyringErr
+	}
+
+	return true, nil
+}
+
+func (store *CredentialStore) DeleteClientPrivateKey(clientName string) (bool, error) {
+	keyringErr := store.KeyRingService.Delete(privateKeyName(clientName))
+
+	if keyringErr != nil {
+		return false, keyringErr
+	}
+
+	return true, nil
+}
+
+// SetClientCertificate stores the certificate against the client, and its private key in the keychain
+func (store *CredentialStore) SetClientCertificate(client OidcClient, certificate string, certificateKey string) (bool, error) {
+	validateErr := oidc.ValidateClientCertificate(certificate, certificateKey)
+
+	if validateErr != nil {
+		return false, validateErr
+	}
+
+	keyringErr := store.KeyRingService.Set(certificateKeyName(client.Alias), certificateKey)
+
+	if keyringErr != nil {
+		return false, keyringErr
+	}
+
+	client.ClientCertificate = certificate
+
+	return store.SaveClientMetadata(client)
+}
+
+// GetDPoPKey loads the connection's DPoP key from the keychain, generating one on first use
+func (store *CredentialStore) GetDPoPKey(clientName string) (string, error) {
+	dpopKey, keyringErr := store.KeyRingService.Get(dpopKeyName(clientName))
+
+	if keyringErr == nil {
+		return dpopKey, nil
+	}
+
+	log.Printf("Generating a DPoP key for %s", clientName)
+
+	dpopKey, generateErr := oidc.GenerateDPoPKey()
+
+	if generateErr != nil {
+		return "", generateErr
+	}
+
+	keyringErr = store.KeyRingService.Set(dpopKeyName(clientName), dpopKey)
+
+	if keyringErr != nil {
+		return "", keyringErr
+	}
+
+	return dpopKey, nil
+}
+
 func (store *CredentialStore) DeleteClientSecret(clientName string) (bool, error) {
 	keyringErr := store.KeyRingService.Delete(clientName)
 
@@ -216,8 +451,18 @@ func (store *CredentialStore) SaveClientWithSecret(client OidcClient, secret str
 		return false, clientErr
 	}
 
-	// PKCE clients don't have secrets, so skip this step if there's no secret.
-	if client.GrantType == oidc.PKCE {
+	// PKCE and implicit clients don't have secrets, so skip this step if there's no secret.
+	if client.GrantType == oidc.PKCE || client.GrantType == oidc.Implicit {
+		return true, nil
+	}
+
+	// Neither do clients authenticating with their TLS certificate
+	if client.AuthMethod == oidc.TlsClientAuth {
+		return true, nil
+	}
+
+	// Device code and JWT bearer clients may be public clients too
+	if secretIsOptional(client) && secret == "" {
 		return true, nil
 	}
 
@@ -234,6 +479,26 @@ func (store *CredentialStore) SaveClientWithSecret(client OidcClient, secret str
 	return true, nil
 }
 
+func (store *CredentialStore) SaveClientWithPrivateKey(client OidcClient, privateKey string) (bool, error) {
+	if privateKey == "" {
+		return false, fmt.Errorf("No private key provided")
+	}
+
+	_, keyErr := store.SetClientPrivateKey(client.Alias, privateKey)
+
+	if keyErr != nil {
+		return false, keyErr
+	}
+
+	_, clientErr := store.SaveClientMetadata(client)
+
+	if clientErr != nil {
+		return false, clientErr
+	}
+
+	return true, nil
+}
+
 func (store *CredentialStore) DeleteClient(clientName string) (bool, error) {
 	clients, clientsErr := store.GetClients()
 
@@ -241,16 +506,47 @@ func (store *CredentialStore) DeleteClient(clientName string) (bool, error) {
 		return false, clientsErr
 	}
 
-	if _, ok := clients[clientName]; !ok {
+	client, ok := clients[clientName]
+
+	if !ok {
 		return false, errors.New("the client does not exist")
 	}
 
-	_, keyringErr := store.DeleteClientSecret(clientName)
+	var keyringErr error
+
+	if client.AuthMethod == oidc.PrivateKeyJwt || client.GrantType == oidc.JwtBearer {
+		_, keyringErr = store.DeleteClientPrivateKey(clientName)
+	}
+
+	if keyringErr == nil && client.AuthMethod != oidc.PrivateKeyJwt && client.AuthMethod != oidc.TlsClientAuth {
+		_, keyringErr = store.DeleteClientSecret(clientName)
+
+		// Public clients have no secret to delete
+		if keyringErr != nil && secretIsOptional(client) {
+			keyringErr = nil
+		}
+	}
 
 	if keyringErr != nil {
 		return false, keyringErr
 	}
 
+	if client.ClientCertificate != "" {
+		certificateErr := store.KeyRingService.Delete(certificateKeyName(clientName))
+
+		if certificateErr != nil {
+			log.Printf("No client certificate key to delete for %s", clientName)
+		}
+	}
+
+	if client.UseDPoP {
+		dpopErr := store.KeyRingService.Delete(dpopKeyName(clientName))
+
+		if dpopErr != nil {
+			log.Printf("No DPoP key to delete for %s", clientName)
+		}
+	}
+
 	tokenErr := store.DeleteTokens(clientName)
 
 	if tokenErr != nil {
@@ -290,6 +586,13 @@ func (store *CredentialStore) GetTokens(clientName string) (oidc.TokenResultSet,
 }
 
 func (store *CredentialStore) DeleteTokens(clientName string) error {
+	// The tokens for the connection's resources go too, though they may never have been requested
+	if clients, clientsErr := store.GetClients(); clientsErr == nil {
+		for _, resource := range clients[clientName].Resources {
+			store.DeleteResourceTokens(clientName, resource)
+		}
+	}
+
 	keyringErr := store.KeyRingService.DeleteTokens(clientName)
 
 	if keyringErr != nil {
@@ -298,3 +601,17 @@ func (store *CredentialStore) DeleteTokens(clientName string) error {
 
 	return nil
 }
+
+// SaveResourceTokens keeps the access token for one of the connection's resources apart from its main token set
+// https://datatracker.ietf.org/doc/html/rfc8707#section-2.2
+func (store *CredentialStore) SaveResourceTokens(clientName string, resource string, tokenSet oidc.TokenResultSet) (bool, error) {
+	return store.SaveTokens(resourceTokensName(clientName, resource), tokenSet)
+}
+
+func (store *CredentialStore) GetResourceTokens(clientName string, resource string) (oidc.TokenResultSet, error) {
+	return store.GetTokens(resourceTokensName(clientName, resource))
+}
+
+func (store *CredentialStore) DeleteResourceTokens(clientName string, resource string) error {
+	return store.KeyRingService.DeleteTokens(resourceTokensName(clientName, resource))
+}
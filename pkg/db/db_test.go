@@ -0,0 +1,28 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+)
+
+func TestAccessTokenValidationPolicy(t *testing.T) {
+	var cases = []struct {
+		name     string
+		client   OidcClient
+		expected string
+	}{
+		// Saved before the policy could be chosen, when only the signature and issuer were checked
+		{"legacy client credentials", OidcClient{GrantType: oidc.ClientCredentials}, oidc.AccessTokenValidationSignature},
+		{"legacy code flow", OidcClient{GrantType: oidc.PKCE}, oidc.AccessTokenValidationNone},
+		{"client credentials with jwt", OidcClient{GrantType: oidc.ClientCredentials, AccessTokenValidation: oidc.AccessTokenValidationJwt}, oidc.AccessTokenValidationJwt},
+		{"client credentials with none", OidcClient{GrantType: oidc.ClientCredentials, AccessTokenValidation: oidc.AccessTokenValidationNone}, oidc.AccessTokenValidationNone},
+		{"code flow with introspect", OidcClient{GrantType: oidc.AuthorisationCode, AccessTokenValidation: oidc.AccessTokenValidationIntrospect}, oidc.AccessTokenValidationIntrospect},
+	}
+
+	for _, c := range cases {
+		if actual := c.client.AccessTokenValidationPolicy(); actual != c.expected {
+			t.Errorf("%s: AccessTokenValidationPolicy = %q, expected %q", c.name, actual, c.expected)
+		}
+	}
+}
@@ -36,17 +36,8 @@ func (service WindowsKeyRingService) Get(item string) (string, error) {
 	return result, nil
 }
 
+// Delete removes a single entry, such as a secret or key. Token sets are split across several, so DeleteTokens removes those
 func (service WindowsKeyRingService) Delete(item string) error {
-
-	err := keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.identity", item))
-	err = keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.refresh", item))
-	err = keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.access", item))
-	err = keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.expiry", item))
-
-	if err != nil {
-		return err
-	}
-
 	return keyring.Delete(KeyRingServiceName, item)
 }
 
@@ -83,11 +74,30 @@ func (service WindowsKeyRingService) GetTokens(item string) (oidc.TokenResultSet
 		return result, convErr
 	}
 
+	// Older token sets were saved without a token type
+	tokenType, _ := service.Get(fmt.Sprintf("%s.token_type", item))
+
+	// Older token sets were saved without these, and providers needn't return a scope
+	scope, _ := service.Get(fmt.Sprintf("%s.scope", item))
+	tokenEndpoint, _ := service.Get(fmt.Sprintf("%s.token_endpoint", item))
+
+	// Only token sets granted with authorization_details have them
+	var authorisationDetails oidc.AuthorisationDetails
+	details, detailsErr := service.Get(fmt.Sprintf("%s.authorization_details", item))
+
+	if detailsErr == nil {
+		authorisationDetails, _ = oidc.ParseAuthorisationDetails([]byte(details))
+	}
+
 	result = oidc.TokenResultSet{
-		IdentityToken: identity,
-		RefreshToken:  refresh,
-		AccessToken:   access,
-		ExpiresAt:     expiryInt,
+		IdentityToken:        identity,
+		RefreshToken:         refresh,
+		AccessToken:          access,
+		TokenType:            tokenType,
+		ExpiresAt:            expiryInt,
+		Scope:                scope,
+		TokenEndpoint:        tokenEndpoint,
+		AuthorisationDetails: authorisationDetails,
 	}
 
 	return result, nil
@@ -105,15 +115,40 @@ func (service WindowsKeyRingService) SetTokens(item string, tokens oidc.TokenRes
 		}
 	}
 
-	if tokens.IdentityToken != "" {
-		err = service.Set(fmt.Sprintf("%s.identity", item), tokens.IdentityToken)
-		if err != nil {
-			return err
+	var authorisationDetails string
+
+	if len(tokens.AuthorisationDetails) > 0 {
+		details, encodeErr := tokens.AuthorisationDetails.Encode()
+
+		if encodeErr != nil {
+			return encodeErr
 		}
+
+		authorisationDetails = details
 	}
 
-	if tokens.RefreshToken != "" {
-		err = service.Set(fmt.Sprintf("%s.refresh", item), tokens.RefreshToken)
+	var optionalPieces = []struct {
+		name  string
+		value string
+	}{
+		{"identity", tokens.IdentityToken},
+		{"refresh", tokens.RefreshToken},
+		{"token_type", tokens.TokenType},
+		{"scope", tokens.Scope},
+		{"token_endpoint", tokens.TokenEndpoint},
+		{"authorization_details", authorisationDetails},
+	}
+
+	for _, piece := range optionalPieces {
+		var pieceName = fmt.Sprintf("%s.%s", item, piece.name)
+
+		// Remove what the previous token set saved, so it isn't read back with this one
+		if piece.value == "" {
+			keyring.Delete(KeyRingServiceName, pieceName)
+			continue
+		}
+
+		err = service.Set(pieceName, piece.value)
 		if err != nil {
 			return err
 		}
@@ -135,5 +170,11 @@ func (service WindowsKeyRingService) DeleteTokens(item string) error {
 	err = keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.access", item))
 	err = keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.expiry", item))
 
+	// Older token sets were saved without a token type, so ignore errors here
+	keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.token_type", item))
+	keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.authorization_details", item))
+	keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.scope", item))
+	keyring.Delete(KeyRingServiceName, fmt.Sprintf("%s.token_endpoint", item))
+
 	return err
 }
@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// How xoauth checks the access tokens it receives for a connection
+const AccessTokenValidationNone = "none"
+
+// https://datatracker.ietf.org/doc/html/rfc9068#section-4
+const AccessTokenValidationJwt = "jwt"
+
+// https://tools.ietf.org/html/rfc7662
+const AccessTokenValidationIntrospect = "introspect"
+
+// Only the signature, issuer and expiry are checked, as xoauth did before the policy could be chosen.
+// Providers that sign their access tokens with a `typ` of `JWT` pass this, but not the jwt policy
+const AccessTokenValidationSignature = "signature"
+
+// The typ header of JWT access tokens
+// https://datatracker.ietf.org/doc/html/rfc9068#section-2.1
+const AccessTokenJwtType = "at+jwt"
+
+var AccessTokenValidationPolicies = []string{AccessTokenValidationNone, AccessTokenValidationJwt, AccessTokenValidationIntrospect, AccessTokenValidationSignature}
+
+// AccessTokenPolicy says how to validate a connection's access tokens, and what they should contain
+type AccessTokenPolicy struct {
+	// One of AccessTokenValidationPolicies. Tokens aren't validated when empty
+	Validation string
+	ClientAuth ClientAuthentication
+	// The scopes that were requested
+	Scopes []string
+	// The resource server the token is for. When empty, any audience is accepted
+	Audience          string
+	AllowedAlgorithms []string
+}
+
+// ValidateAccessToken checks an access token according to the connection's policy
+func ValidateAccessToken(accessToken string, configuration WellKnownConfiguration, policy AccessTokenPolicy) error {
+	switch policy.Validation {
+	case "", AccessTokenValidationNone:
+		return nil
+	case AccessTokenValidationJwt:
+		log.Println("Validating access token as a JWT")
+		return validateJwtAccessToken(accessToken, configuration, policy)
+	case AccessTokenValidationSignature:
+		log.Println("Validating access token signature")
+		_, _, parseErr := parseJwtAccessToken(accessToken, configuration, policy)
+		return parseErr
+	case AccessTokenValidationIntrospect:
+		log.Println("Validating access token with the introspection endpoint")
+		return validateIntrospectedAccessToken(accessToken, configuration, policy)
+	}
+
+	return fmt.Errorf("unknown access token validation policy %q. Use one of %s", policy.Validation, strings.Join(AccessTokenValidationPolicies, ", "))
+}
+
+// parseJwtAccessToken checks the access token's signature, issuer and expiry
+func parseJwtAccessToken(accessToken string, configuration WellKnownConfiguration, policy AccessTokenPolicy) (*jwt.Token, jwt.MapClaims, error) {
+	if strings.Count(accessToken, ".") != 2 {
+		return nil, nil, errors.New("the access token isn't a JWT. Use the introspect or none access token validation policy for providers that issue opaque tokens")
+	}
+
+	// Providers don't advertise the algorithms they sign access tokens with, and the ID token's may differ, so RS256 is the default
+	// https://datatracker.ietf.org/doc/html/rfc9068#section-2.1
+	token, claims, parseErr := parseSignedToken(accessToken, configuration, AllowedAlgorithms(policy.AllowedAlgorithms, nil), jwt.WithLeeway(clockTolerance))
+
+	if parseErr != nil {
+		return nil, nil, fmt.Errorf("invalid access token: %v", parseErr)
+	}
+
+	return token, claims, nil
+}
+
+// https://datatracker.ietf.org/doc/html/rfc9068#section-4
+func validateJwtAccessToken(accessToken string, configuration WellKnownConfiguration, policy AccessTokenPolicy) error {
+	token, claims, parseErr := parseJwtAccessToken(accessToken, configuration, policy)
+
+	if parseErr != nil {
+		return parseErr
+	}
+
+	tokenType, _ := token.Header["typ"].(string)
+
+	if !strings.EqualFold(tokenType, AccessTokenJwtType) && !strings.EqualFold(tokenType, "application/"+AccessTokenJwtType) {
+		return fmt.Errorf("invalid access token (typ): expected %q, got %q", AccessTokenJwtType, tokenType)
+	}
+
+	var audiences = audienceList(claims)
+
+	if len(audiences) == 0 {
+		return errors.New("invalid access token (aud): the token has no audience")
+	}
+
+	if policy.Audience != "" && !containsString(audiences, policy.Audience) {
+		return fmt.Errorf("invalid access token (aud): the token was issued for %v, not for %q", audiences, policy.Audience)
+	}
+
+	if clientId, _ := claims["client_id"].(string); clientId != policy.ClientAuth.ClientId {
+		return fmt.Errorf("invalid access token (client_id): the token was issued to %q, not to client %q", clientId, policy.ClientAuth.ClientId)
+	}
+
+	scope, _ := claims["scope"].(string)
+	warnAboutMissingScopes(policy.Scopes, scope)
+
+	return nil
+}
+
+func validateIntrospectedAccessToken(accessToken string, configuration WellKnownConfiguration, policy AccessTokenPolicy) error {
+	result, introspectErr := IntrospectToken(configuration.IntrospectionEndpoint, policy.ClientAuth, accessToken, AccessTokenHint)
+
+	if introspectErr != nil {
+		return introspectErr
+	}
+
+	if !result.Active {
+		return errors.New("invalid access token: the introspection endpoint says it isn't active")
+	}
+
+	if result.ClientId != "" && result.ClientId != policy.ClientAuth.ClientId {
+		return fmt.Errorf("invalid access token (client_id): the token was issued to %q, not to client %q", result.ClientId, policy.ClientAuth.ClientId)
+	}
+
+	warnAboutMissingScopes(policy.Scopes, result.Scope)
+
+	return nil
+}
+
+// The provider may grant fewer scopes than were requested, so this is a warning rather than an error
+// https://tools.ietf.org/html/rfc6749#section-3.3
+func warnAboutMissingScopes(requested []string, granted string) {
+	var grantedScopes = strings.Fields(granted)
+
+	for _, scope := range requested {
+		// OpenID scopes control the ID token and refresh token, so they needn't be in the access token
+		if scope == "openid" || scope == "offline_access" {
+			continue
+		}
+
+		if !containsString(grantedScopes, scope) {
+			log.Printf("The access token wasn't granted the %q scope", scope)
+		}
+	}
+}
+
+func containsString(values []string, needle string) bool {
+	for _, value := range values {
+		if value == needle {
+			return true
+		}
+	}
+
+	return false
+}
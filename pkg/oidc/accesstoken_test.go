@@ -0,0 +1,111 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// testProvider serves the key from a JWKS endpoint
+func testProvider(key *rsa.PrivateKey) (WellKnownConfiguration, func()) {
+	var jwks = fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"test","use":"sig","alg":"RS256","n":%q,"e":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(jwks))
+	}))
+
+	return WellKnownConfiguration{Issuer: "https://identity.example.com", JwksUri: server.URL}, server.Close
+}
+
+// signAccessToken signs the claims with the key, with the given typ header
+func signAccessToken(t *testing.T, key *rsa.PrivateKey, tokenType string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	token.Header["typ"] = tokenType
+
+	signed, signErr := token.SignedString(key)
+
+	if signErr != nil {
+		t.Fatal(signErr)
+	}
+
+	return signed
+}
+
+func TestValidateAccessToken(t *testing.T) {
+	key, keyErr := rsa.GenerateKey(rand.Reader, 2048)
+
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+
+	configuration, closeServer := testProvider(key)
+	defer closeServer()
+
+	var now = time.Now()
+
+	// Like the access tokens from identity servers that predate RFC 9068
+	var legacyClaims = jwt.MapClaims{
+		"iss":       configuration.Issuer,
+		"exp":       now.Add(time.Hour).Unix(),
+		"client_id": "client",
+	}
+
+	var profileClaims = jwt.MapClaims{
+		"iss":       configuration.Issuer,
+		"exp":       now.Add(time.Hour).Unix(),
+		"aud":       "https://api.example.com",
+		"client_id": "client",
+	}
+
+	var expiredClaims = jwt.MapClaims{
+		"iss": configuration.Issuer,
+		"exp": now.Add(-time.Hour).Unix(),
+	}
+
+	var otherIssuerClaims = jwt.MapClaims{
+		"iss": "https://attacker.example",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var cases = []struct {
+		name       string
+		validation string
+		token      string
+		valid      bool
+	}{
+		{"legacy token, signature policy", AccessTokenValidationSignature, signAccessToken(t, key, "JWT", legacyClaims), true},
+		{"legacy token, jwt policy", AccessTokenValidationJwt, signAccessToken(t, key, "JWT", legacyClaims), false},
+		{"RFC 9068 token, signature policy", AccessTokenValidationSignature, signAccessToken(t, key, AccessTokenJwtType, profileClaims), true},
+		{"RFC 9068 token, jwt policy", AccessTokenValidationJwt, signAccessToken(t, key, AccessTokenJwtType, profileClaims), true},
+		{"expired, signature policy", AccessTokenValidationSignature, signAccessToken(t, key, "JWT", expiredClaims), false},
+		{"another issuer, signature policy", AccessTokenValidationSignature, signAccessToken(t, key, "JWT", otherIssuerClaims), false},
+		{"another key, signature policy", AccessTokenValidationSignature, signAccessToken(t, otherKey, "JWT", legacyClaims), false},
+		{"opaque token, signature policy", AccessTokenValidationSignature, "opaque-token", false},
+		{"opaque token, none policy", AccessTokenValidationNone, "opaque-token", true},
+	}
+
+	for _, c := range cases {
+		var validateErr = ValidateAccessToken(c.token, configuration, AccessTokenPolicy{
+			Validation: c.validation,
+			ClientAuth: ClientAuthentication{ClientId: "client"},
+		})
+
+		if (validateErr == nil) != c.valid {
+			t.Errorf("%s: ValidateAccessToken = %v, expected valid: %t", c.name, validateErr, c.valid)
+		}
+	}
+}
@@ -19,6 +19,14 @@ type TokenResultSet struct {
 	TokenType     string `json:"token_type"`
 	ExpiresIn     int    `json:"expires_in"`
 	ExpiresAt     int64  `json:"expires_at"`
+	// The scopes granted, when they differ from those requested
+	// https://tools.ietf.org/html/rfc6749#section-5.1
+	Scope string `json:"scope,omitempty"`
+	// Where the tokens came from, so they're refreshed at the same endpoint
+	TokenEndpoint string `json:"token_endpoint,omitempty"`
+	// What the provider granted, which may be less than was asked for
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-7
+	AuthorisationDetails AuthorisationDetails `json:"authorization_details,omitempty"`
 }
 
 type AccessTokenResultSet struct {
@@ -26,30 +34,75 @@ type AccessTokenResultSet struct {
 	TokenType   string `json:"token_type"`
 	ExpiresIn   int    `json:"expires_in"`
 	ExpiresAt   int64  `json:"expires_at"`
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-7
+	AuthorisationDetails AuthorisationDetails `json:"authorization_details,omitempty"`
 }
 
-func BuildCodeAuthorisationRequest(configuration WellKnownConfiguration, clientId string, redirectUri string, scopes []string, state string, codeChallenge string) string {
-	urlToBuild, urlErr := url.Parse(configuration.AuthorisationEndpoint)
+func BuildCodeAuthorisationRequest(configuration WellKnownConfiguration, clientId string, redirectUri string, scopes []string, state string, nonce string, codeChallenge string) string {
+	q := CodeAuthorisationParameters(clientId, redirectUri, scopes, state, nonce, codeChallenge, ResponseModeQuery)
 
-	if urlErr != nil {
-		log.Fatal(urlErr)
-	}
+	return BuildAuthorisationUrl(configuration, q)
+}
+
+// CodeAuthorisationParameters are the parameters of an authorisation request for the code flow
+func CodeAuthorisationParameters(clientId string, redirectUri string, scopes []string, state string, nonce string, codeChallenge string, responseMode string) url.Values {
+	return AuthorisationParameters(ResponseTypeCode, clientId, redirectUri, scopes, state, nonce, codeChallenge, responseMode)
+}
 
+// AuthorisationParameters are the parameters of an authorisation request for any response type
+func AuthorisationParameters(responseType string, clientId string, redirectUri string, scopes []string, state string, nonce string, codeChallenge string, responseMode string) url.Values {
 	scope := strings.Join(scopes, " ")
 
+	// Tokens returned from the authorisation endpoint go in the fragment by default, so they aren't sent to servers
+	// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#Combinations
+	if responseMode == "" && responseType != ResponseTypeCode {
+		responseMode = ResponseModeFragment
+	}
+
+	if responseMode == "" {
+		responseMode = ResponseModeQuery
+	}
+
 	q := url.Values{}
-	q.Add("response_type", "code")
-	q.Add("response_mode", "query")
+	q.Add("response_type", responseType)
+	q.Add("response_mode", responseMode)
 	q.Add("client_id", clientId)
 	q.Add("redirect_uri", redirectUri)
 	q.Add("scope", scope)
 	q.Add("state", state)
 
+	// Binds the ID token to this request, so a replayed token is rejected
+	// https://openid.net/specs/openid-connect-core-1_0.html#NonceNotes
+	if nonce != "" {
+		q.Add("nonce", nonce)
+	}
+
 	if codeChallenge != "" {
 		q.Add("code_challenge", codeChallenge)
 		q.Add("code_challenge_method", "S256")
 	}
 
+	return q
+}
+
+// ResponseTypeIncludes reports whether a response type returns the given value, e.g. code or id_token
+func ResponseTypeIncludes(responseType string, value string) bool {
+	for _, part := range strings.Fields(responseType) {
+		if part == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func BuildAuthorisationUrl(configuration WellKnownConfiguration, q url.Values) string {
+	urlToBuild, urlErr := url.Parse(configuration.AuthorisationEndpoint)
+
+	if urlErr != nil {
+		log.Fatal(urlErr)
+	}
+
 	urlToBuild.RawQuery = q.Encode()
 
 	return urlToBuild.String()
@@ -58,11 +111,69 @@ func BuildCodeAuthorisationRequest(configuration WellKnownConfiguration, clientI
 type AuthorisationResponse struct {
 	Code  string
 	State string
+	// Returned from the authorisation endpoint by the hybrid and implicit flows
+	IdToken     string
+	AccessToken string
+	TokenType   string
+	ExpiresIn   int
+}
+
+// AuthorisationError is an error the provider redirected back with, instead of a code
+// https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+type AuthorisationError struct {
+	Code        string
+	Description string
+	Uri         string
+}
+
+// What the standard error codes mean, for when the provider doesn't describe them
+var authorisationErrorHints = map[string]string{
+	"invalid_request":           "the authorisation request was malformed or missing a parameter",
+	"unauthorized_client":       "the client isn't allowed to use this grant type",
+	"access_denied":             "the user or the provider refused the request",
+	"unsupported_response_type": "the provider doesn't support this response type",
+	"invalid_scope":             "one of the requested scopes is unknown or not allowed for this client",
+	"server_error":              "the provider hit an unexpected error",
+	"temporarily_unavailable":   "the provider is temporarily unavailable",
+	// https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+	"interaction_required":       "the user needs to interact with the provider",
+	"login_required":             "the user needs to sign in",
+	"consent_required":           "the user needs to consent to the request",
+	"account_selection_required": "the user needs to choose an account",
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-5
+	"invalid_authorization_details": "the provider doesn't understand or won't grant the authorization_details",
+}
+
+func (err AuthorisationError) Error() string {
+	var description = err.Description
+
+	if description == "" {
+		description = authorisationErrorHints[err.Code]
+	}
+
+	var message = fmt.Sprintf("the provider returned %s", err.Code)
+
+	if description != "" {
+		message = fmt.Sprintf("%s: %s", message, description)
+	}
+
+	if err.Uri != "" {
+		message = fmt.Sprintf("%s (see %s)", message, err.Uri)
+	}
+
+	return message
 }
 
-func ValidateAuthorisationResponse(url *url.URL, state string) (AuthorisationResponse, error) {
+// IsTemporary reports whether the provider may accept the same request later
+func (err AuthorisationError) IsTemporary() bool {
+	return err.Code == "temporarily_unavailable" || err.Code == "server_error"
+}
+
+// ValidateAuthorisationResponse reads the code from the provider's redirect, after checking
+// it's a response to our request from the provider we sent it to.
+// The parameters come from the query string, or the body when the response mode is form_post
+func ValidateAuthorisationResponse(query url.Values, state string, configuration WellKnownConfiguration, responseType string) (AuthorisationResponse, error) {
 	var response AuthorisationResponse
-	var query = url.Query()
 	var code = query.Get("code")
 	var stateFromQuery = query.Get("state")
 
@@ -70,64 +181,134 @@ func ValidateAuthorisationResponse(url *url.URL, state string) (AuthorisationRes
 		return response, errors.New(`oidc Error: state parameters don't match`)
 	}
 
-	if code == "" {
+	// The issuer identifies which provider sent the response, which defeats mix-up attacks.
+	// It's checked for errors as well as codes
+	// https://datatracker.ietf.org/doc/html/rfc9207#section-2.4
+	if issErr := validateResponseIssuer(query, configuration); issErr != nil {
+		return response, issErr
+	}
+
+	if errorCode := query.Get("error"); errorCode != "" {
+		return response, AuthorisationError{
+			Code:        errorCode,
+			Description: query.Get("error_description"),
+			Uri:         query.Get("error_uri"),
+		}
+	}
+
+	if code == "" && ResponseTypeIncludes(responseType, "code") {
 		return response, errors.New(`oidc Error: no code in OIDC response`)
 	}
 
+	if query.Get("id_token") == "" && ResponseTypeIncludes(responseType, "id_token") {
+		return response, errors.New(`oidc Error: no id_token in OIDC response`)
+	}
+
+	if query.Get("access_token") == "" && ResponseTypeIncludes(responseType, "token") {
+		return response, errors.New(`oidc Error: no access_token in OIDC response`)
+	}
+
+	expiresIn, _ := strconv.Atoi(query.Get("expires_in"))
+
 	response = AuthorisationResponse{
-		Code:  code,
-		State: state,
+		Code:        code,
+		State:       state,
+		IdToken:     query.Get("id_token"),
+		AccessToken: query.Get("access_token"),
+		TokenType:   query.Get("token_type"),
+		ExpiresIn:   expiresIn,
 	}
 
 	return response, nil
 }
 
-func FormPost(tokenEndpoint string, clientId string, clientSecret string, formData url.Values, result interface{}) error {
-
-	client := &http.Client{}
+func validateResponseIssuer(query url.Values, configuration WellKnownConfiguration) error {
+	var _, hasIssuer = query["iss"]
 
-	encoded := formData.Encode()
-	request, requestBuildErr := http.NewRequest("POST", tokenEndpoint, strings.NewReader(encoded))
+	if !hasIssuer && configuration.AuthorisationResponseIssParameterSupported {
+		return errors.New("oidc Error: the provider advertises the iss parameter, but the response doesn't have one")
+	}
 
-	if requestBuildErr != nil {
-		return requestBuildErr
+	if hasIssuer && query.Get("iss") != configuration.Issuer {
+		return fmt.Errorf("oidc Error: the response is from issuer %q, not %q. It may be from a different provider", query.Get("iss"), configuration.Issuer)
 	}
 
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	return nil
+}
 
-	if clientSecret != "" {
-		request.SetBasicAuth(clientId, clientSecret)
+// postForm sends a url-encoded form to an OAuth endpoint, authenticating the client with
+// the configured method. The caller is responsible for closing the response body
+func postForm(endpoint string, auth ClientAuthentication, formData url.Values) (*http.Response, error) {
+	if IsOffline() {
+		return nil, fmt.Errorf("xoauth is in offline mode, so can't send a request to %s", endpoint)
 	}
 
-	request.Header.Add("Content-Length", strconv.Itoa(len(encoded)))
+	client, clientErr := auth.httpClient()
 
-	response, responseErr := client.Do(request)
+	if clientErr != nil {
+		return nil, clientErr
+	}
 
-	if responseErr != nil {
-		return errors.New(fmt.Sprintf("Error POSTing code %v", responseErr))
+	// Token requests carry a DPoP proof, and may need to be retried once with the provider's nonce
+	// https://datatracker.ietf.org/doc/html/rfc9449#section-8
+	var useDPoP = auth.UsesDPoP() && formData.Get("grant_type") != ""
+
+	if formData.Get("grant_type") != "" {
+		formData = auth.withTokenParameters(formData)
 	}
 
-	decoder := json.NewDecoder(response.Body)
+	return sendRequest(client, endpoint, useDPoP, isReplaySafe(formData), func(dpopNonce string) (*http.Request, error) {
+		// Rebuild the form each time, so client assertions aren't replayed
+		authenticatedForm, useBasicAuth, authErr := auth.authenticateForm(endpoint, formData)
+
+		if authErr != nil {
+			return nil, authErr
+		}
 
-	if response.StatusCode != 200 {
-		var errorResult interface{}
-		endpointErr := decoder.Decode(&errorResult)
+		encoded := authenticatedForm.Encode()
+		request, requestBuildErr := http.NewRequestWithContext(requestOptions.Context, "POST", endpoint, strings.NewReader(encoded))
 
-		if endpointErr != nil {
-			return fmt.Errorf("received error from code endpoint, but unable to deserialise error message. statusCode: %d", response.StatusCode)
+		if requestBuildErr != nil {
+			return nil, requestBuildErr
 		}
 
-		errorBody, errorBodyError := json.MarshalIndent(errorResult, "", " ")
+		request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		if useBasicAuth {
+			request.SetBasicAuth(auth.ClientId, auth.ClientSecret)
+		}
+
+		request.Header.Add("Content-Length", strconv.Itoa(len(encoded)))
+
+		if useDPoP {
+			proof, proofErr := BuildDPoPProof(auth.DPoPKey, "POST", endpoint, dpopNonce, "")
+
+			if proofErr != nil {
+				return nil, proofErr
+			}
 
-		if errorBodyError != nil {
-			return errorBodyError
+			request.Header.Add("DPoP", proof)
 		}
 
-		return fmt.Errorf("received error from code endpoint. statusCode: %d, body: %s",
-			response.StatusCode,
-			string(errorBody))
+		return request, nil
+	})
+}
+
+func FormPost(tokenEndpoint string, auth ClientAuthentication, formData url.Values, result interface{}) error {
+	response, responseErr := postForm(tokenEndpoint, auth, formData)
+
+	if responseErr != nil {
+		return responseErr
 	}
 
+	defer response.Body.Close()
+
+	// Most endpoints respond with a 200, but the PAR endpoint responds with a 201
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		return readOAuthError(tokenEndpoint, response)
+	}
+
+	decoder := json.NewDecoder(response.Body)
 	decodeErr := decoder.Decode(&result)
 
 	if decodeErr != nil {
@@ -137,7 +318,7 @@ func FormPost(tokenEndpoint string, clientId string, clientSecret string, formDa
 	return nil
 }
 
-func ExchangeCodeForToken(tokenEndpoint string, code string, clientId string, clientSecret string, codeVerifier string, redirectUri string) (TokenResultSet, error) {
+func ExchangeCodeForToken(tokenEndpoint string, code string, auth ClientAuthentication, codeVerifier string, redirectUri string, authorisationDetails AuthorisationDetails, resources []string) (TokenResultSet, error) {
 	var result TokenResultSet
 
 	log.Printf("Exchanging code at token endpoint: %s\n", tokenEndpoint)
@@ -153,22 +334,30 @@ func ExchangeCodeForToken(tokenEndpoint string, code string, clientId string, cl
 		formData.Add("code_verifier", codeVerifier)
 	}
 
-	// https://tools.ietf.org/html/rfc6749#section-4.1.3
-	if clientSecret == "" {
-		formData.Add("client_id", clientId)
+	addResources(formData, resources)
+
+	if detailsErr := addAuthorisationDetails(formData, authorisationDetails); detailsErr != nil {
+		return result, detailsErr
 	}
 
-	var postError = FormPost(tokenEndpoint, clientId, clientSecret, formData, &result)
+	var postError = FormPost(tokenEndpoint, auth, formData, &result)
 	if postError != nil {
 		return result, postError
 	}
 
+	warnIfNotDPoPBound(auth, result.TokenType)
+
+	if bindingErr := verifyIfCertificateBound(auth, result.AccessToken); bindingErr != nil {
+		return result, bindingErr
+	}
+
 	result.ExpiresAt = AbsoluteExpiry(time.Now(), result.ExpiresIn)
+	result.TokenEndpoint = tokenEndpoint
 
 	return result, nil
 }
 
-func RequestWithClientCredentials(tokenEndpoint string, clientId string, clientSecret string, scope string) (AccessTokenResultSet, error) {
+func RequestWithClientCredentials(tokenEndpoint string, auth ClientAuthentication, scope string, authorisationDetails AuthorisationDetails, resources []string) (AccessTokenResultSet, error) {
 	var result AccessTokenResultSet
 
 	log.Printf("Requesting token with client credentials grant: %s\n", tokenEndpoint)
@@ -178,16 +367,36 @@ func RequestWithClientCredentials(tokenEndpoint string, clientId string, clientS
 		"scope":      {scope},
 	}
 
-	var postError = FormPost(tokenEndpoint, clientId, clientSecret, formData, &result)
+	addResources(formData, resources)
+
+	if detailsErr := addAuthorisationDetails(formData, authorisationDetails); detailsErr != nil {
+		return result, detailsErr
+	}
+
+	var postError = FormPost(tokenEndpoint, auth, formData, &result)
 	if postError != nil {
 		return result, postError
 	}
 
+	warnIfNotDPoPBound(auth, result.TokenType)
+
+	if bindingErr := verifyIfCertificateBound(auth, result.AccessToken); bindingErr != nil {
+		return result, bindingErr
+	}
+
 	result.ExpiresAt = AbsoluteExpiry(time.Now(), result.ExpiresIn)
 
 	return result, nil
 }
 
+// addResources asks for tokens restricted to the resources the client will use them with
+// https://datatracker.ietf.org/doc/html/rfc8707#section-2
+func addResources(formData url.Values, resources []string) {
+	for _, resource := range resources {
+		formData.Add("resource", resource)
+	}
+}
+
 func AbsoluteExpiry(now time.Time, expiresIn int) int64 {
 	// ExpiresIn returns expiry time in seconds
 	var future = now.Add(time.Second * time.Duration(expiresIn))
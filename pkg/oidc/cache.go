@@ -0,0 +1,243 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long to keep metadata when the provider's cache headers don't say
+const DefaultCacheTTL = time.Hour
+
+type CacheOptions struct {
+	// Where cached documents are written. Caching is disabled when empty
+	Directory string
+	// How long to keep a document when the response has no cache headers
+	TTL time.Duration
+	// Serve cached documents, however old, and never touch the network
+	Offline bool
+}
+
+var cacheOptions = CacheOptions{TTL: DefaultCacheTTL}
+
+type cacheEntry struct {
+	Url       string          `json:"url"`
+	FetchedAt int64           `json:"fetched_at"`
+	ExpiresAt int64           `json:"expires_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+func ConfigureCache(options CacheOptions) {
+	cacheOptions = options
+}
+
+func IsOffline() bool {
+	return cacheOptions.Offline
+}
+
+var errOffline = errors.New("xoauth is in offline mode, and there's nothing cached to use instead")
+
+func cacheFile(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return filepath.Join(cacheOptions.Directory, hex.EncodeToString(hash[:])+".json")
+}
+
+func readCache(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+
+	if cacheOptions.Directory == "" {
+		return entry, false
+	}
+
+	data, readErr := ioutil.ReadFile(cacheFile(key))
+
+	if readErr != nil {
+		return entry, false
+	}
+
+	if json.Unmarshal(data, &entry) != nil {
+		return entry, false
+	}
+
+	return entry, true
+}
+
+func writeCache(key string, entry cacheEntry) {
+	if cacheOptions.Directory == "" {
+		return
+	}
+
+	if mkdirErr := os.MkdirAll(cacheOptions.Directory, 0700); mkdirErr != nil {
+		log.Printf("Unable to create cache directory: %v", mkdirErr)
+		return
+	}
+
+	data, _ := json.Marshal(entry)
+
+	// The cache is only an optimisation, so failing to write it isn't fatal
+	if writeErr := ioutil.WriteFile(cacheFile(key), data, 0600); writeErr != nil {
+		log.Printf("Unable to write cache: %v", writeErr)
+	}
+}
+
+// cacheLifetime reads how long a response may be reused from its Cache-Control and Expires headers.
+// It's false when the response mustn't be stored at all
+// https://tools.ietf.org/html/rfc7234#section-4.2.1
+func cacheLifetime(header http.Header, now time.Time) (time.Duration, bool) {
+	var directives = strings.Split(header.Get("Cache-Control"), ",")
+	var noCache = false
+
+	for index, directive := range directives {
+		directives[index] = strings.ToLower(strings.TrimSpace(directive))
+
+		// https://tools.ietf.org/html/rfc7234#section-5.2.2.3
+		if directives[index] == "no-store" {
+			return 0, false
+		}
+
+		noCache = noCache || directives[index] == "no-cache"
+	}
+
+	// Kept for offline use, but already stale so it's fetched again next time
+	// https://tools.ietf.org/html/rfc7234#section-5.2.2.2
+	if noCache {
+		return 0, true
+	}
+
+	for _, directive := range directives {
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, parseErr := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+
+			if parseErr == nil {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, parseErr := http.ParseTime(expires)
+
+		if parseErr == nil {
+			return expiresAt.Sub(now), true
+		}
+	}
+
+	return cacheOptions.TTL, true
+}
+
+// getJsonDocument fetches a JSON document, serving it from the cache while it's fresh. A new document is only
+// cached once it passes validate, if given, so a bad one isn't served again on later runs.
+// A 4xx, or a document that isn't cached when offline, is returned as a nil body so callers can try somewhere else
+func getJsonDocument(cacheKey string, documentUrl string, forceRefetch bool, transport TransportOptions, validate func(body []byte) error) ([]byte, error) {
+	cacheKey = cacheKey + " " + transport.cacheKey()
+	cached, isCached := readCache(cacheKey)
+
+	if cacheOptions.Offline {
+		if !isCached {
+			return nil, nil
+		}
+
+		return cached.Body, nil
+	}
+
+	var now = time.Now()
+
+	if isCached && !forceRefetch && now.Unix() < cached.ExpiresAt {
+		return cached.Body, nil
+	}
+
+	client, clientErr := transport.httpClient(nil)
+
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	request, requestErr := http.NewRequestWithContext(requestOptions.Context, http.MethodGet, documentUrl, nil)
+
+	if requestErr != nil {
+		return nil, requestErr
+	}
+
+	response, requestErr := client.Do(request)
+
+	if requestErr != nil && isCached {
+		log.Printf("Unable to reach %s, using the cached copy from %s: %v", documentUrl, time.Unix(cached.FetchedAt, 0).Format(time.RFC3339), requestErr)
+		return cached.Body, nil
+	}
+
+	if requestErr != nil {
+		return nil, requestErr
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 && response.StatusCode < 500 {
+		return nil, nil
+	}
+
+	if response.StatusCode != 200 {
+		var statusErr = fmt.Errorf("got %d when requesting %s", response.StatusCode, documentUrl)
+
+		// The provider is having trouble, which is no reason to stop using what it said before
+		if response.StatusCode >= 500 && isCached {
+			log.Printf("Unable to reach %s, using the cached copy from %s: %v", documentUrl, time.Unix(cached.FetchedAt, 0).Format(time.RFC3339), statusErr)
+			return cached.Body, nil
+		}
+
+		return nil, statusErr
+	}
+
+	body, readErr := ioutil.ReadAll(response.Body)
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("the response from %s isn't valid JSON", documentUrl)
+	}
+
+	if validate != nil {
+		if validateErr := validate(body); validateErr != nil {
+			return nil, validateErr
+		}
+	}
+
+	lifetime, storable := cacheLifetime(response.Header, now)
+
+	if !storable {
+		return body, nil
+	}
+
+	writeCache(cacheKey, cacheEntry{
+		Url:       documentUrl,
+		FetchedAt: now.Unix(),
+		ExpiresAt: now.Add(lifetime).Unix(),
+		Body:      body,
+	})
+
+	return body, nil
+}
+
+// getUnexpiringJsonDocument fetches a JSON document once, then serves it from the cache until it's refetched,
+// whatever the provider's cache headers say
+func getUnexpiringJsonDocument(cacheKey string, documentUrl string, refetch bool, transport TransportOptions) ([]byte, error) {
+	cached, isCached := readCache(cacheKey + " " + transport.cacheKey())
+
+	if isCached && !refetch {
+		return cached.Body, nil
+	}
+
+	return getJsonDocument(cacheKey, documentUrl, true, transport, nil)
+}
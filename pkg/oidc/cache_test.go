@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheLifetime(t *testing.T) {
+	var now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var cases = []struct {
+		name             string
+		cacheControl     string
+		expires          string
+		expectedLifetime time.Duration
+		expectedStorable bool
+	}{
+		{"no headers", "", "", DefaultCacheTTL, true},
+		{"max-age", "public, max-age=600", "", 10 * time.Minute, true},
+		{"max-age over Expires", "max-age=60", now.Add(time.Hour).Format(http.TimeFormat), time.Minute, true},
+		{"Expires", "", now.Add(time.Hour).Format(http.TimeFormat), time.Hour, true},
+		{"no-cache", "no-cache", "", 0, true},
+		{"no-cache with max-age", "max-age=600, no-cache", "", 0, true},
+		{"no-store", "no-store", "", 0, false},
+		{"no-store after max-age", "max-age=600, No-Store", "", 0, false},
+	}
+
+	for _, c := range cases {
+		var header = http.Header{}
+
+		if c.cacheControl != "" {
+			header.Set("Cache-Control", c.cacheControl)
+		}
+
+		if c.expires != "" {
+			header.Set("Expires", c.expires)
+		}
+
+		lifetime, storable := cacheLifetime(header, now)
+
+		if lifetime != c.expectedLifetime || storable != c.expectedStorable {
+			t.Errorf("%s: cacheLifetime = (%v, %t), expected (%v, %t)", c.name, lifetime, storable, c.expectedLifetime, c.expectedStorable)
+		}
+	}
+}
+
+func TestGetJsonDocumentCaching(t *testing.T) {
+	var cases = []struct {
+		name           string
+		cacheControl   string
+		expectedCached bool
+	}{
+		{"cacheable", "max-age=600", true},
+		{"no-cache", "no-cache", true},
+		{"no-store", "no-store", false},
+	}
+
+	defer ConfigureCache(cacheOptions)
+
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", c.cacheControl)
+			w.Write([]byte(`{"issuer":"https://example.com"}`))
+		}))
+
+		directory, dirErr := ioutil.TempDir("", "xoauth-cache")
+
+		if dirErr != nil {
+			t.Fatal(dirErr)
+		}
+
+		ConfigureCache(CacheOptions{Directory: directory, TTL: DefaultCacheTTL})
+
+		body, fetchErr := getJsonDocument("test", server.URL, false, TransportOptions{}, nil)
+		server.Close()
+
+		if fetchErr != nil || body == nil {
+			t.Fatalf("%s: getJsonDocument = (%s, %v)", c.name, body, fetchErr)
+		}
+
+		files, _ := ioutil.ReadDir(directory)
+
+		if cached := len(files) > 0; cached != c.expectedCached {
+			t.Errorf("%s: cached = %t, expected %t", c.name, cached, c.expectedCached)
+		}
+	}
+}
+
+func TestTransportCacheKey(t *testing.T) {
+	var direct = TransportOptions{}.cacheKey()
+
+	for _, options := range []TransportOptions{
+		{Proxy: "http://proxy.example.com:8080"},
+		{CACertificateFile: "/etc/ssl/internal.pem"},
+		{MinTLSVersion: "1.3"},
+		{InsecureSkipVerify: true},
+	} {
+		if options.cacheKey() == direct {
+			t.Errorf("%+v has the same cache key as a direct connection", options)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// Client authentication methods
+// https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication
+const ClientSecretBasic = "client_secret_basic"
+const PrivateKeyJwt = "private_key_jwt"
+
+// https://tools.ietf.org/html/rfc8705#section-2.1
+const TlsClientAuth = "tls_client_auth"
+
+// https://tools.ietf.org/html/rfc7523#section-2.2
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// How long a client assertion is valid for
+const clientAssertionLifetime = 2 * time.Minute
+
+// ClientAuthentication holds everything needed to authenticate a client at the provider's endpoints
+type ClientAuthentication struct {
+	Method       string
+	ClientId     string
+	ClientSecret string
+	// A PEM encoded private key, used to sign client assertions for private_key_jwt
+	PrivateKey string
+	// A PEM encoded certificate and private key, presented to the provider over mutual TLS
+	Certificate    string
+	CertificateKey string
+	// A PEM encoded P-256 private key, used to sign DPoP proofs on token requests
+	DPoPKey string
+	// Extra parameters sent with every token request
+	TokenParameters map[string]string
+	// The provider's issuer, which every client assertion is addressed to
+	Issuer string
+	// How to reach the provider: a proxy, extra CAs and TLS options
+	Transport TransportOptions
+}
+
+// withTokenParameters adds the connection's extra token request parameters to a copy of the form.
+// They replace any parameter xoauth would send, so they can be used to test how the provider handles it
+func (auth ClientAuthentication) withTokenParameters(formData url.Values) url.Values {
+	var extended = url.Values{}
+
+	for key, values := range formData {
+		extended[key] = append([]string{}, values...)
+	}
+
+	for key, value := range auth.TokenParameters {
+		extended.Set(key, value)
+	}
+
+	return extended
+}
+
+// authenticateForm adds the client authentication parameters to a copy of the form.
+// It returns true if the request should also be authenticated with HTTP Basic
+func (auth ClientAuthentication) authenticateForm(endpoint string, formData url.Values) (url.Values, bool, error) {
+	var authenticated = url.Values{}
+
+	for key, values := range formData {
+		authenticated[key] = append([]string{}, values...)
+	}
+
+	if auth.Method == PrivateKeyJwt {
+		// Providers may refuse an assertion addressed to their revocation, introspection, PAR or device
+		// endpoints, but accept the issuer wherever the assertion is sent
+		// https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication
+		var audience = auth.Issuer
+
+		if audience == "" {
+			audience = endpoint
+		}
+
+		assertion, assertionErr := BuildClientAssertion(auth.ClientId, audience, auth.PrivateKey)
+
+		if assertionErr != nil {
+			return authenticated, false, assertionErr
+		}
+
+		// https://tools.ietf.org/html/rfc7523#section-2.2
+		authenticated.Set("client_id", auth.ClientId)
+		authenticated.Set("client_assertion_type", ClientAssertionType)
+		authenticated.Set("client_assertion", assertion)
+
+		return authenticated, false, nil
+	}
+
+	// The TLS client certificate authenticates the client, so it only needs to identify itself
+	// https://tools.ietf.org/html/rfc8705#section-2
+	if auth.Method == TlsClientAuth {
+		authenticated.Set("client_id", auth.ClientId)
+		return authenticated, false, nil
+	}
+
+	// Public clients identify themselves with client_id alone
+	// https://tools.ietf.org/html/rfc6749#section-2.3.1
+	if auth.ClientSecret == "" {
+		authenticated.Set("client_id", auth.ClientId)
+		return authenticated, false, nil
+	}
+
+	return authenticated, true, nil
+}
+
+// ParsePrivateKey reads an RSA or EC private key from a PEM block, in PKCS#1, PKCS#8 or SEC 1 form
+func ParsePrivateKey(pemData string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(pemData))
+
+	if block == nil {
+		return nil, errors.New("unable to find a PEM block in the private key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %v", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// SigningMethodForKey picks the JWS algorithm to sign with, based on the type of key
+func SigningMethodForKey(key interface{}) (jwt.SigningMethod, error) {
+	switch typedKey := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch typedKey.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256, nil
+		case 384:
+			return jwt.SigningMethodES384, nil
+		case 521:
+			return jwt.SigningMethodES512, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// SignWithPrivateKey signs a set of claims with a PEM encoded private key
+func SignWithPrivateKey(claims jwt.MapClaims, pemData string) (string, error) {
+	key, keyErr := ParsePrivateKey(pemData)
+
+	if keyErr != nil {
+		return "", keyErr
+	}
+
+	method, methodErr := SigningMethodForKey(key)
+
+	if methodErr != nil {
+		return "", methodErr
+	}
+
+	return jwt.NewWithClaims(method, claims).SignedString(key)
+}
+
+// BuildClientAssertion creates a signed JWT to authenticate the client with
+// https://tools.ietf.org/html/rfc7523#section-3
+func BuildClientAssertion(clientId string, audience string, pemData string) (string, error) {
+	if pemData == "" {
+		return "", errors.New("no private key is configured for private_key_jwt client authentication")
+	}
+
+	jti, jtiErr := GenerateRandomStringURLSafe(24)
+
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	var now = time.Now()
+
+	claims := jwt.MapClaims{
+		"iss": clientId,
+		"sub": clientId,
+		"aud": audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	}
+
+	return SignWithPrivateKey(claims, pemData)
+}
@@ -3,5 +3,32 @@ package oidc
 const PKCE = "PKCE"
 const ClientCredentials = "client_credentials"
 const AuthorisationCode = "authorization_code"
+const DeviceCode = "device_code"
+const JwtBearer = "jwt_bearer"
 
+// https://openid.net/specs/openid-connect-core-1_0.html#HybridFlowAuth
+const Hybrid = "hybrid"
 
+// https://openid.net/specs/openid-connect-core-1_0.html#ImplicitFlowAuth
+const Implicit = "implicit"
+
+// https://tools.ietf.org/html/rfc8628#section-3.4
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// https://tools.ietf.org/html/rfc7523#section-2.1
+const JwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// How the provider returns the authorisation response to the redirect URI
+// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+const ResponseModeQuery = "query"
+
+// https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+const ResponseModeFormPost = "form_post"
+
+// The default for response types that return tokens from the authorisation endpoint
+const ResponseModeFragment = "fragment"
+
+// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#Combinations
+const ResponseTypeCode = "code"
+const ResponseTypeHybrid = "code id_token"
+const ResponseTypeImplicit = "id_token token"
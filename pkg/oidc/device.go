@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// The default polling interval, used when the provider doesn't specify one
+// https://tools.ietf.org/html/rfc8628#section-3.2
+const defaultDeviceInterval = 5
+
+// How long to keep polling when the provider doesn't say when the device code expires
+const defaultDeviceExpiry = 30 * time.Minute
+
+type DeviceAuthorisationResult struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func RequestDeviceAuthorisation(deviceEndpoint string, auth ClientAuthentication, scope string, resources []string, extraParameters map[string]string) (DeviceAuthorisationResult, error) {
+	var result DeviceAuthorisationResult
+
+	if deviceEndpoint == "" {
+		return result, errors.New("no device authorisation endpoint in OIDC metadata")
+	}
+
+	log.Printf("Requesting device code from: %s\n", deviceEndpoint)
+
+	// https://tools.ietf.org/html/rfc8628#section-3.1
+	formData := url.Values{
+		"scope": {scope},
+	}
+
+	addResources(formData, resources)
+
+	for key, value := range extraParameters {
+		formData.Set(key, value)
+	}
+
+	var postError = FormPost(deviceEndpoint, auth, formData, &result)
+
+	if postError != nil {
+		return result, postError
+	}
+
+	if result.DeviceCode == "" || result.UserCode == "" {
+		return result, errors.New("device authorisation response is missing the device_code or user_code")
+	}
+
+	if result.Interval <= 0 {
+		result.Interval = defaultDeviceInterval
+	}
+
+	return result, nil
+}
+
+// PollForDeviceToken polls the token endpoint until the user has approved (or denied) the device
+// authorisation request, or the device code expires
+// https://tools.ietf.org/html/rfc8628#section-3.4
+func PollForDeviceToken(tokenEndpoint string, auth ClientAuthentication, authorisation DeviceAuthorisationResult) (TokenResultSet, error) {
+	var result TokenResultSet
+
+	formData := url.Values{
+		"grant_type":  {DeviceCodeGrantType},
+		"device_code": {authorisation.DeviceCode},
+	}
+
+	var interval = time.Duration(authorisation.Interval) * time.Second
+	var deadline = time.Now().Add(defaultDeviceExpiry)
+
+	if authorisation.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(authorisation.ExpiresIn) * time.Second)
+	}
+
+	log.Printf("Polling token endpoint: %s\n", tokenEndpoint)
+
+	for time.Now().Before(deadline) {
+		if waitErr := waitToRetry(interval); waitErr != nil {
+			return result, waitErr
+		}
+
+		response, responseErr := postForm(tokenEndpoint, auth, formData)
+
+		if responseErr != nil {
+			return result, responseErr
+		}
+
+		if response.StatusCode == 200 {
+			decodeErr := json.NewDecoder(response.Body).Decode(&result)
+			response.Body.Close()
+
+			if decodeErr != nil {
+				return result, fmt.Errorf("failed to decode JSON %v", decodeErr)
+			}
+
+			warnIfNotDPoPBound(auth, result.TokenType)
+
+			if bindingErr := verifyIfCertificateBound(auth, result.AccessToken); bindingErr != nil {
+				return result, bindingErr
+			}
+
+			result.ExpiresAt = AbsoluteExpiry(time.Now(), result.ExpiresIn)
+			result.TokenEndpoint = tokenEndpoint
+
+			return result, nil
+		}
+
+		var pollErr = readOAuthError(tokenEndpoint, response)
+		response.Body.Close()
+
+		// https://tools.ietf.org/html/rfc8628#section-3.5
+		switch pollErr.Code {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+			log.Printf("Slowing down, polling every %v\n", interval)
+			continue
+		case "access_denied":
+			return result, errors.New("the device authorisation request was denied")
+		case "expired_token":
+			return result, errors.New("the device code expired before the request was approved")
+		default:
+			return result, pollErr
+		}
+	}
+
+	return result, errors.New("the device code expired before the request was approved")
+}
@@ -4,71 +4,158 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
+	"strings"
 )
 
 const WellKnownPath = "/.well-known/openid-configuration"
 
+// https://tools.ietf.org/html/rfc8414#section-3
+const OAuthWellKnownPath = "/.well-known/oauth-authorization-server"
+
+type WellKnownConfiguration struct {
+	Issuer                             string              `json:"issuer"`
+	AuthorisationEndpoint              string              `json:"authorization_endpoint"`
+	TokenEndpoint                      string              `json:"token_endpoint"`
+	UserInfoEndpoint                   string              `json:"userinfo_endpoint"`
+	EndSessionEndpoint                 string              `json:"end_session_endpoint"`
+	DeviceAuthorisationEndpoint        string              `json:"device_authorization_endpoint"`
+	RevocationEndpoint                 string              `json:"revocation_endpoint"`
+	IntrospectionEndpoint              string              `json:"introspection_endpoint"`
+	PushedAuthorisationRequestEndpoint string              `json:"pushed_authorization_request_endpoint"`
+	RequirePushedAuthorisationRequests bool                `json:"require_pushed_authorization_requests"`
+	MtlsEndpointAliases                MtlsEndpointAliases `json:"mtls_endpoint_aliases"`
+	// https://datatracker.ietf.org/doc/html/rfc9207#section-3
+	AuthorisationResponseIssParameterSupported bool   `json:"authorization_response_iss_parameter_supported"`
+	JwksUri                                    string `json:"jwks_uri"`
+
+	ScopesSupported                        []string `json:"scopes_supported"`
+	ResponseTypesSupported                 []string `json:"response_types_supported"`
+	ResponseModesSupported                 []string `json:"response_modes_supported"`
+	GrantTypesSupported                    []string `json:"grant_types_supported"`
+	SubjectTypesSupported                  []string `json:"subject_types_supported"`
+	ClaimsSupported                        []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported          []string `json:"code_challenge_methods_supported"`
+	IdTokenSigningAlgValuesSupported       []string `json:"id_token_signing_alg_values_supported"`
+	UserInfoSigningAlgValuesSupported      []string `json:"userinfo_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported      []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgsSupported  []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionAuthMethodsSupported      []string `json:"introspection_endpoint_auth_methods_supported"`
+	DPoPSigningAlgValuesSupported          []string `json:"dpop_signing_alg_values_supported"`
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-10
+	AuthorisationDetailsTypesSupported []string `json:"authorization_details_types_supported"`
+
+	// How the metadata was fetched, so the signing keys are fetched the same way
+	transport TransportOptions
+}
+
+// metadataUrls lists where the metadata for an issuer may be found, in the order to try them.
+// OpenID Connect appends the well-known path to the issuer, while RFC 8414 inserts it between the host and the path
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationRequest
+// https://tools.ietf.org/html/rfc8414#section-3.1
+func metadataUrls(authority string) ([]string, error) {
+	parsed, parseErr := url.Parse(authority)
 
-func GetSchemeAndHost(urlString string) (string, error) {
-	parsed, err := url.Parse(urlString)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	var baseUrl = fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host)
+	var issuerPath = strings.TrimSuffix(parsed.Path, "/")
+
+	return []string{
+		fmt.Sprintf("%s%s%s", baseUrl, issuerPath, WellKnownPath),
+		fmt.Sprintf("%s%s%s", baseUrl, OAuthWellKnownPath, issuerPath),
+	}, nil
+}
 
-	if err != nil {
-		return "", err
+// checkIssuer guards against a metadata document impersonating another issuer
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+// https://tools.ietf.org/html/rfc8414#section-3.3
+func checkIssuer(configuration WellKnownConfiguration, authority string) error {
+	if configuration.Issuer != authority {
+		return fmt.Errorf("the issuer in the OIDC metadata %q doesn't match the authority %q. Check the authority configured for this connection", configuration.Issuer, authority)
 	}
 
-	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host), nil
+	return nil
 }
 
+// fetchMetadata returns false if there's no metadata document at the URL, so the next one can be tried.
+// Documents are cached per authority, so the same issuer isn't looked up on every command
+func fetchMetadata(authority string, wellKnownUrl string, transport TransportOptions, result *WellKnownConfiguration) (bool, error) {
+	log.Printf("Requesting OIDC metadata from %s\n", wellKnownUrl)
+
+	body, fetchErr := getJsonDocument("metadata:"+authority+" "+wellKnownUrl, wellKnownUrl, false, transport, func(body []byte) error {
+		var document WellKnownConfiguration
+
+		if decodeErr := json.Unmarshal(body, &document); decodeErr != nil {
+			return decodeErr
+		}
+
+		return checkIssuer(document, authority)
+	})
+
+	if fetchErr != nil {
+		return false, fetchErr
+	}
+
+	if body == nil {
+		return false, nil
+	}
+
+	decodeErr := json.Unmarshal(body, result)
 
-type WellKnownConfiguration struct{
-	AuthorisationEndpoint string `json:"authorization_endpoint"`
-	TokenEndpoint string `json:"token_endpoint"`
-	JwksUri string `json:"jwks_uri"`
-	Issuer string `json:"issuer"`
+	if decodeErr != nil {
+		return false, decodeErr
+	}
+
+	return true, nil
 }
 
+func GetMetadata(authority string, transport TransportOptions) (WellKnownConfiguration, error) {
+	var result = WellKnownConfiguration{transport: transport}
 
-func GetMetadata(authority string) (WellKnownConfiguration, error) {
-	var authorityBaseUrl, parseErr = GetSchemeAndHost(authority)
-	var result WellKnownConfiguration
+	wellKnownUrls, parseErr := metadataUrls(authority)
 
 	if parseErr != nil {
 		return result, parseErr
 	}
 
-	var wellKnownUrl = fmt.Sprintf("%s%s", authorityBaseUrl, WellKnownPath)
+	var found = false
 
-	log.Printf("Requesting OIDC metadata from %s\n", wellKnownUrl)
+	for _, wellKnownUrl := range wellKnownUrls {
+		var fetchErr error
 
-	response, requestErr := http.Get(wellKnownUrl)
+		found, fetchErr = fetchMetadata(authority, wellKnownUrl, transport, &result)
 
-	if requestErr != nil {
-		return result, requestErr
-	}
+		if fetchErr != nil {
+			return result, fetchErr
+		}
 
-	if response.StatusCode != 200 {
-		return result, fmt.Errorf("got %d when requesting %s", response.StatusCode, wellKnownUrl)
+		if found {
+			break
+		}
 	}
 
-	decoder := json.NewDecoder(response.Body)
-	decodeErr := decoder.Decode(&result)
+	if !found && IsOffline() {
+		return result, fmt.Errorf("%v: no metadata cached for %s", errOffline, authority)
+	}
 
-	if decodeErr != nil {
-		return result, decodeErr
+	if !found {
+		return result, fmt.Errorf("no OIDC or OAuth metadata found for %s", authority)
 	}
 
 	log.Printf("Received OIDC metadata for authority: %s", result.Issuer)
 
-	if result.TokenEndpoint == "" {
-		return result, fmt.Errorf("no token endpoint in OIDC metadata")
+	// Documents cached before they were checked are checked again
+	if issuerErr := checkIssuer(result, authority); issuerErr != nil {
+		return result, issuerErr
 	}
 
-	if result.AuthorisationEndpoint == "" {
-		return result, fmt.Errorf("no authorisation endpoint in OIDC metadata")
+	if result.TokenEndpoint == "" {
+		return result, fmt.Errorf("no token endpoint in OIDC metadata")
 	}
 
 	return result, nil
 }
-
@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataUrls(t *testing.T) {
+	var cases = []struct {
+		authority string
+		expected  []string
+	}{
+		{
+			"https://identity.xero.com",
+			[]string{
+				"https://identity.xero.com/.well-known/openid-configuration",
+				"https://identity.xero.com/.well-known/oauth-authorization-server",
+			},
+		},
+		{
+			"https://example.com/",
+			[]string{
+				"https://example.com/.well-known/openid-configuration",
+				"https://example.com/.well-known/oauth-authorization-server",
+			},
+		},
+		{
+			"https://example.com/tenant/v2",
+			[]string{
+				"https://example.com/tenant/v2/.well-known/openid-configuration",
+				"https://example.com/.well-known/oauth-authorization-server/tenant/v2",
+			},
+		},
+		{
+			"http://localhost:8080/realms/test/",
+			[]string{
+				"http://localhost:8080/realms/test/.well-known/openid-configuration",
+				"http://localhost:8080/.well-known/oauth-authorization-server/realms/test",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		actual, parseErr := metadataUrls(c.authority)
+
+		if parseErr != nil {
+			t.Errorf("metadataUrls(%q) failed: %v", c.authority, parseErr)
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("metadataUrls(%q) = %v, expected %v", c.authority, actual, c.expected)
+		}
+	}
+}
+
+func TestCheckIssuer(t *testing.T) {
+	var cases = []struct {
+		issuer    string
+		authority string
+		valid     bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com/tenant", "https://example.com/tenant", true},
+		{"https://example.com/", "https://example.com", false},
+		{"https://example.com/other", "https://example.com/tenant", false},
+		{"https://attacker.example", "https://example.com", false},
+		{"", "https://example.com", false},
+	}
+
+	for _, c := range cases {
+		var issuerErr = checkIssuer(WellKnownConfiguration{Issuer: c.issuer}, c.authority)
+
+		if (issuerErr == nil) != c.valid {
+			t.Errorf("checkIssuer(%q, %q) = %v, expected valid: %t", c.issuer, c.authority, issuerErr, c.valid)
+		}
+	}
+}
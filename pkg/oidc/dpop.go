@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/gookit/color"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc9449#section-5
+const DPoPTokenType = "DPoP"
+
+// Nonces handed out by the provider, keyed by endpoint, to be sent on the next proof
+var dpopNonces = map[string]string{}
+
+// UsesDPoP is true when token requests should be bound to the client's DPoP key
+func (auth ClientAuthentication) UsesDPoP() bool {
+	return auth.DPoPKey != ""
+}
+
+// GenerateDPoPKey creates a new P-256 key pair to sign DPoP proofs with, PEM encoded
+func GenerateDPoPKey() (string, error) {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if keyErr != nil {
+		return "", keyErr
+	}
+
+	der, marshalErr := x509.MarshalPKCS8PrivateKey(key)
+
+	if marshalErr != nil {
+		return "", marshalErr
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func padCoordinate(coordinate []byte, size int) []byte {
+	padded := make([]byte, size)
+	copy(padded[size-len(coordinate):], coordinate)
+	return padded
+}
+
+// BuildDPoPProof signs a proof of possession for a single HTTP request. When an access token is
+// supplied, the proof is bound to it with the `ath` claim, for calls to resource servers
+// https://datatracker.ietf.org/doc/html/rfc9449#section-4.2
+func BuildDPoPProof(keyPem string, method string, requestUrl string, nonce string, accessToken string) (string, error) {
+	privateKey, keyErr := ParsePrivateKey(keyPem)
+
+	if keyErr != nil {
+		return "", keyErr
+	}
+
+	ecKey, isEcKey := privateKey.(*ecdsa.PrivateKey)
+
+	if !isEcKey || ecKey.Curve != elliptic.P256() {
+		return "", errors.New("DPoP proofs must be signed with a P-256 key")
+	}
+
+	target, urlErr := url.Parse(requestUrl)
+
+	if urlErr != nil {
+		return "", urlErr
+	}
+
+	// The htu claim excludes the query and fragment
+	target.RawQuery = ""
+	target.Fragment = ""
+
+	jti, jtiErr := GenerateRandomStringURLSafe(24)
+
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	claims := jwt.MapClaims{
+		"jti": jti,
+		"htm": strings.ToUpper(method),
+		"htu": target.String(),
+		"iat": time.Now().Unix(),
+	}
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	if accessToken != "" {
+		claims["ath"] = GenerateBase64Sha256Hash(accessToken)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(padCoordinate(ecKey.X.Bytes(), 32)),
+		"y":   base64.RawURLEncoding.EncodeToString(padCoordinate(ecKey.Y.Bytes(), 32)),
+	}
+
+	return token.SignedString(ecKey)
+}
+
+// requiresDPoPNonce checks for a use_dpop_nonce error, leaving the response body intact for the caller
+// https://datatracker.ietf.org/doc/html/rfc9449#section-8
+func requiresDPoPNonce(response *http.Response) bool {
+	if response.StatusCode != 400 && response.StatusCode != 401 {
+		return false
+	}
+
+	if strings.Contains(response.Header.Get("WWW-Authenticate"), "use_dpop_nonce") {
+		return true
+	}
+
+	body, readErr := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if readErr != nil {
+		return false
+	}
+
+	var errorResult struct {
+		Error string `json:"error"`
+	}
+
+	if json.Unmarshal(body, &errorResult) != nil {
+		return false
+	}
+
+	return errorResult.Error == "use_dpop_nonce"
+}
+
+// warnIfNotDPoPBound flags a provider that ignored the DPoP proof and issued a bearer token instead
+func warnIfNotDPoPBound(auth ClientAuthentication, tokenType string) {
+	if auth.UsesDPoP() && !strings.EqualFold(tokenType, DPoPTokenType) {
+		log.Printf("%s", color.Yellow.Sprintf("Requested a DPoP-bound token, but received token_type %q", tokenType))
+	}
+}
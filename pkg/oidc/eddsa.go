@@ -0,0 +1,53 @@
+package oidc
+
+import (
+	"crypto/ed25519"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// SigningMethodEdDSA verifies Ed25519 signatures, which jwt-go doesn't support
+// https://tools.ietf.org/html/rfc8037#section-3.1
+type SigningMethodEdDSA struct{}
+
+var EdDSA = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA.Alg(), func() jwt.SigningMethod {
+		return EdDSA
+	})
+}
+
+func (method *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (method *SigningMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+
+	if !ok {
+		return jwt.NewInvalidKeyTypeError("ed25519.PublicKey", key)
+	}
+
+	sig, decodeErr := jwt.DecodeSegment(signature)
+
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return new(jwt.InvalidSignatureError)
+	}
+
+	return nil
+}
+
+func (method *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+
+	if !ok {
+		return "", jwt.NewInvalidKeyTypeError("ed25519.PrivateKey", key)
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
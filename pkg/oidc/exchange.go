@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc8693#section-2.1
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// Token type identifiers
+// https://datatracker.ietf.org/doc/html/rfc8693#section-3
+const AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+const RefreshTokenType = "urn:ietf:params:oauth:token-type:refresh_token"
+const IdTokenType = "urn:ietf:params:oauth:token-type:id_token"
+const JwtTokenType = "urn:ietf:params:oauth:token-type:jwt"
+
+type TokenExchangeRequest struct {
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	Audience           string
+	Resource           string
+	RequestedTokenType string
+	Scope              string
+}
+
+// https://datatracker.ietf.org/doc/html/rfc8693#section-2.2.1
+type TokenExchangeResult struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	ExpiresIn       int    `json:"expires_in"`
+	ExpiresAt       int64  `json:"expires_at"`
+}
+
+// ExchangeToken swaps a subject token (and optionally an actor token) for a new token
+// https://datatracker.ietf.org/doc/html/rfc8693#section-2.1
+func ExchangeToken(tokenEndpoint string, auth ClientAuthentication, request TokenExchangeRequest) (TokenExchangeResult, error) {
+	var result TokenExchangeResult
+
+	if request.SubjectToken == "" {
+		return result, errors.New("no subject token to exchange")
+	}
+
+	log.Printf("Exchanging token at token endpoint: %s\n", tokenEndpoint)
+
+	formData := url.Values{
+		"grant_type":         {TokenExchangeGrantType},
+		"subject_token":      {request.SubjectToken},
+		"subject_token_type": {request.SubjectTokenType},
+	}
+
+	if request.ActorToken != "" {
+		formData.Add("actor_token", request.ActorToken)
+		formData.Add("actor_token_type", request.ActorTokenType)
+	}
+
+	if request.Audience != "" {
+		formData.Add("audience", request.Audience)
+	}
+
+	if request.Resource != "" {
+		formData.Add("resource", request.Resource)
+	}
+
+	if request.RequestedTokenType != "" {
+		formData.Add("requested_token_type", request.RequestedTokenType)
+	}
+
+	if request.Scope != "" {
+		formData.Add("scope", request.Scope)
+	}
+
+	var postError = FormPost(tokenEndpoint, auth, formData, &result)
+
+	if postError != nil {
+		return result, postError
+	}
+
+	warnIfNotDPoPBound(auth, result.TokenType)
+
+	// Only access tokens are bound to the certificate. ID tokens and refresh tokens can be exchanged for too
+	if result.IssuedTokenType == AccessTokenType || result.IssuedTokenType == JwtTokenType {
+		if bindingErr := verifyIfCertificateBound(auth, result.AccessToken); bindingErr != nil {
+			return result, bindingErr
+		}
+	}
+
+	result.ExpiresAt = AbsoluteExpiry(time.Now(), result.ExpiresIn)
+
+	return result, nil
+}
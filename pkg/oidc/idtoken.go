@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"crypto"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// Don't send max_age with the authorisation request
+const NoMaxAge = -1
+
+// What the ID token is expected to contain, based on the request that was made for it
+type IdTokenExpectations struct {
+	ClientId string
+	// The nonce sent with the authorisation request
+	Nonce string
+	// The max_age sent with the authorisation request, in seconds, or NoMaxAge
+	MaxAge int
+	// Tokens issued alongside the ID token, to check against at_hash and c_hash
+	AccessToken string
+	Code        string
+	// Hybrid and implicit responses must include at_hash and c_hash for the tokens they carry
+	RequireAccessTokenHash bool
+	RequireCodeHash        bool
+	// The algorithms the connection accepts. When empty, those the provider advertises
+	AllowedAlgorithms []string
+}
+
+// IdTokenError describes which check the ID token failed
+type IdTokenError struct {
+	Claim   string
+	Message string
+}
+
+func (err IdTokenError) Error() string {
+	return fmt.Sprintf("invalid ID token (%s): %s", err.Claim, err.Message)
+}
+
+// ValidateIdToken checks the signature, issuer and expiry of an ID token, then the claims the client is responsible for
+// https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+func ValidateIdToken(idToken string, configuration WellKnownConfiguration, expectations IdTokenExpectations) (jwt.MapClaims, error) {
+	if idToken == "" {
+		return nil, IdTokenError{"id_token", "the provider didn't return an ID token. Check that the openid scope was requested"}
+	}
+
+	token, claims, parseErr := parseSignedToken(idToken, configuration, AllowedAlgorithms(expectations.AllowedAlgorithms, configuration.IdTokenSigningAlgValuesSupported), jwt.WithLeeway(clockTolerance))
+
+	if parseErr != nil {
+		return nil, describeParseError(parseErr)
+	}
+
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, IdTokenError{"sub", "the token has no subject"}
+	}
+
+	if audErr := checkAudience(claims, expectations.ClientId); audErr != nil {
+		return nil, audErr
+	}
+
+	if nonceErr := checkNonce(claims, expectations.Nonce); nonceErr != nil {
+		return nil, nonceErr
+	}
+
+	if authTimeErr := checkAuthTime(claims, expectations.MaxAge, time.Now(), clockTolerance); authTimeErr != nil {
+		return nil, authTimeErr
+	}
+
+	// https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken
+	if hashErr := checkTokenHash(token, claims, "at_hash", expectations.AccessToken, expectations.RequireAccessTokenHash); hashErr != nil {
+		return nil, hashErr
+	}
+
+	// https://openid.net/specs/openid-connect-core-1_0.html#HybridIDToken
+	if hashErr := checkTokenHash(token, claims, "c_hash", expectations.Code, expectations.RequireCodeHash); hashErr != nil {
+		return nil, hashErr
+	}
+
+	return claims, nil
+}
+
+// ValidateRefreshedIdToken validates an ID token issued on refresh. It must be about the same user, from the same
+// provider, as the ID token it replaces, and the user can't have signed in again
+// https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse
+func ValidateRefreshedIdToken(idToken string, previousIdToken string, configuration WellKnownConfiguration, expectations IdTokenExpectations) (jwt.MapClaims, error) {
+	claims, validateErr := ValidateIdToken(idToken, configuration, expectations)
+
+	if validateErr != nil {
+		return nil, validateErr
+	}
+
+	if previousIdToken == "" {
+		return claims, nil
+	}
+
+	// The previous token was validated when it was issued
+	previousClaims, previousErr := UnverifiedClaims(previousIdToken)
+
+	if previousErr != nil {
+		return nil, previousErr
+	}
+
+	for _, claim := range []string{"iss", "sub", "auth_time", "azp"} {
+		_, hasClaim := claims[claim]
+		var optional = claim == "auth_time" || claim == "azp"
+
+		if (hasClaim || !optional) && previousClaims[claim] != claims[claim] {
+			return nil, IdTokenError{claim, "the claim differs from the ID token issued when the user signed in"}
+		}
+	}
+
+	return claims, nil
+}
+
+// describeParseError names the check that failed while verifying the token's signature and standard claims
+func describeParseError(parseErr error) error {
+	var expiredErr *jwt.TokenExpiredError
+	var notValidYetErr *jwt.TokenNotValidYetError
+	var issuerErr *jwt.InvalidIssuerError
+	var signatureErr *jwt.InvalidSignatureError
+	var malformedErr *jwt.MalformedTokenError
+
+	switch {
+	case errors.As(parseErr, &expiredErr):
+		return IdTokenError{"exp", parseErr.Error()}
+	case errors.As(parseErr, &notValidYetErr):
+		return IdTokenError{"nbf", parseErr.Error()}
+	case errors.As(parseErr, &issuerErr):
+		return IdTokenError{"iss", parseErr.Error()}
+	case errors.As(parseErr, &signatureErr):
+		return IdTokenError{"signature", parseErr.Error()}
+	case errors.As(parseErr, &malformedErr):
+		return IdTokenError{"format", parseErr.Error()}
+	}
+
+	return IdTokenError{"signature", parseErr.Error()}
+}
+
+func audienceList(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var audiences []string
+
+		for _, value := range aud {
+			if audience, ok := value.(string); ok {
+				audiences = append(audiences, audience)
+			}
+		}
+
+		return audiences
+	}
+
+	return nil
+}
+
+// The client must be one of the audiences, and if there are others, the authorised party
+func checkAudience(claims jwt.MapClaims, clientId string) error {
+	var audiences = audienceList(claims)
+	var found = false
+
+	for _, audience := range audiences {
+		if audience == clientId {
+			found = true
+		}
+	}
+
+	if !found {
+		return IdTokenError{"aud", fmt.Sprintf("the token was issued for %v, not for client %q", audiences, clientId)}
+	}
+
+	azp, hasAzp := claims["azp"].(string)
+
+	if len(audiences) > 1 && !hasAzp {
+		return IdTokenError{"azp", "the token has several audiences but no authorised party"}
+	}
+
+	if hasAzp && azp != clientId {
+		return IdTokenError{"azp", fmt.Sprintf("the token was issued to %q, not to client %q", azp, clientId)}
+	}
+
+	return nil
+}
+
+func checkNonce(claims jwt.MapClaims, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	nonce, _ := claims["nonce"].(string)
+
+	if nonce == "" {
+		return IdTokenError{"nonce", "the token has no nonce, but one was sent with the request"}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(expected)) != 1 {
+		return IdTokenError{"nonce", "the nonce doesn't match the one sent with the request. The response may have been replayed"}
+	}
+
+	return nil
+}
+
+func checkAuthTime(claims jwt.MapClaims, maxAge int, now time.Time, tolerance time.Duration) error {
+	if maxAge == NoMaxAge {
+		return nil
+	}
+
+	authTime, ok := claims["auth_time"].(float64)
+
+	if !ok {
+		return IdTokenError{"auth_time", "max_age was requested, but the token doesn't say when the user authenticated"}
+	}
+
+	var authenticatedAt = time.Unix(int64(authTime), 0)
+	var oldestAllowed = now.Add(-time.Duration(maxAge)*time.Second - tolerance)
+
+	if authenticatedAt.Before(oldestAllowed) {
+		return IdTokenError{"auth_time", fmt.Sprintf("the user authenticated at %s, longer ago than the requested max_age of %d seconds", authenticatedAt.Format(time.RFC3339), maxAge)}
+	}
+
+	return nil
+}
+
+// hashForAlgorithm picks the hash used for at_hash and c_hash, which is the one the token was signed with
+func hashForAlgorithm(alg string) (crypto.Hash, error) {
+	switch {
+	case alg == "EdDSA":
+		return crypto.SHA512, nil
+	case strings.HasSuffix(alg, "256"):
+		return crypto.SHA256, nil
+	case strings.HasSuffix(alg, "384"):
+		return crypto.SHA384, nil
+	case strings.HasSuffix(alg, "512"):
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("no hash is defined for the %s algorithm", alg)
+}
+
+// TokenHash is the base64url encoded left half of the hash of a token, as used in at_hash and c_hash
+// https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken
+func TokenHash(value string, alg string) (string, error) {
+	hash, hashErr := hashForAlgorithm(alg)
+
+	if hashErr != nil {
+		return "", hashErr
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(value))
+	digest := hasher.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(digest[:len(digest)/2]), nil
+}
+
+func checkTokenHash(token *jwt.Token, claims jwt.MapClaims, claim string, value string, required bool) error {
+	expected, hasClaim := claims[claim].(string)
+
+	if !hasClaim {
+		if required && value != "" {
+			return IdTokenError{claim, "the claim is required for this response type, but the token doesn't have it"}
+		}
+
+		return nil
+	}
+
+	if value == "" {
+		log.Printf("The ID token has a %s claim, but there's nothing to check it against", claim)
+		return nil
+	}
+
+	actual, hashErr := TokenHash(value, token.Method.Alg())
+
+	if hashErr != nil {
+		return IdTokenError{claim, hashErr.Error()}
+	}
+
+	if actual != expected {
+		return IdTokenError{claim, "the hash doesn't match the token it was issued with"}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+func TestCheckAudience(t *testing.T) {
+	var cases = []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{"single audience", jwt.MapClaims{"aud": "client"}, true},
+		{"audience list", jwt.MapClaims{"aud": []interface{}{"client"}}, true},
+		{"another client", jwt.MapClaims{"aud": "other"}, false},
+		{"no audience", jwt.MapClaims{}, false},
+		{"several audiences with azp", jwt.MapClaims{"aud": []interface{}{"client", "api"}, "azp": "client"}, true},
+		{"several audiences without azp", jwt.MapClaims{"aud": []interface{}{"client", "api"}}, false},
+		{"azp for another client", jwt.MapClaims{"aud": []interface{}{"client", "api"}, "azp": "api"}, false},
+		{"single audience with azp for another client", jwt.MapClaims{"aud": "client", "azp": "other"}, false},
+	}
+
+	for _, c := range cases {
+		var audienceErr = checkAudience(c.claims, "client")
+
+		if (audienceErr == nil) != c.valid {
+			t.Errorf("%s: checkAudience = %v, expected valid: %t", c.name, audienceErr, c.valid)
+		}
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	var cases = []struct {
+		alg      string
+		expected string
+	}{
+		{"RS256", "o1uBp9eSe3DsmScN0jYriA"},
+		{"ES384", "8ZYBhGf1HS0O6l_LefILVrCxOJ4-cux2"},
+		{"PS512", "php9CHa4VMkYVLy29EudTMn2qR0zfkdNC24tIP3VP8Y"},
+		{"EdDSA", "php9CHa4VMkYVLy29EudTMn2qR0zfkdNC24tIP3VP8Y"},
+	}
+
+	for _, c := range cases {
+		actual, hashErr := TokenHash("SplxlOBeZQQYbYS6WxSbIA", c.alg)
+
+		if hashErr != nil || actual != c.expected {
+			t.Errorf("TokenHash(%s) = (%q, %v), expected %q", c.alg, actual, hashErr, c.expected)
+		}
+	}
+}
+
+func TestCheckTokenHash(t *testing.T) {
+	var token = &jwt.Token{Method: jwt.SigningMethodRS256}
+	var code = "SplxlOBeZQQYbYS6WxSbIA"
+
+	var cases = []struct {
+		name     string
+		claims   jwt.MapClaims
+		value    string
+		required bool
+		valid    bool
+	}{
+		{"matching hash", jwt.MapClaims{"c_hash": "o1uBp9eSe3DsmScN0jYriA"}, code, true, true},
+		{"different hash", jwt.MapClaims{"c_hash": "o1uBp9eSe3DsmScN0jYriB"}, code, false, false},
+		{"missing required hash", jwt.MapClaims{}, code, true, false},
+		{"missing optional hash", jwt.MapClaims{}, code, false, true},
+		{"nothing to check against", jwt.MapClaims{"c_hash": "o1uBp9eSe3DsmScN0jYriA"}, "", true, true},
+	}
+
+	for _, c := range cases {
+		var hashErr = checkTokenHash(token, c.claims, "c_hash", c.value, c.required)
+
+		if (hashErr == nil) != c.valid {
+			t.Errorf("%s: checkTokenHash = %v, expected valid: %t", c.name, hashErr, c.valid)
+		}
+	}
+}
+
+func TestCheckAuthTime(t *testing.T) {
+	var now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var cases = []struct {
+		name   string
+		claims jwt.MapClaims
+		maxAge int
+		valid  bool
+	}{
+		{"no max_age", jwt.MapClaims{}, NoMaxAge, true},
+		{"recent authentication", jwt.MapClaims{"auth_time": float64(now.Add(-time.Minute).Unix())}, 300, true},
+		{"within the clock tolerance", jwt.MapClaims{"auth_time": float64(now.Add(-8 * time.Minute).Unix())}, 300, true},
+		{"too long ago", jwt.MapClaims{"auth_time": float64(now.Add(-time.Hour).Unix())}, 300, false},
+		{"missing auth_time", jwt.MapClaims{}, 300, false},
+	}
+
+	for _, c := range cases {
+		var authTimeErr = checkAuthTime(c.claims, c.maxAge, now, clockTolerance)
+
+		if (authTimeErr == nil) != c.valid {
+			t.Errorf("%s: checkAuthTime = %v, expected valid: %t", c.name, authTimeErr, c.valid)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package oidc
+
+import (
+	"errors"
+	"log"
+	"net/url"
+)
+
+// https://tools.ietf.org/html/rfc7662#section-2.2
+type IntrospectionResult struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+// IntrospectToken asks the provider whether a token is active, and what it grants
+// https://tools.ietf.org/html/rfc7662#section-2.1
+func IntrospectToken(introspectionEndpoint string, auth ClientAuthentication, token string, tokenTypeHint string) (IntrospectionResult, error) {
+	var result IntrospectionResult
+
+	if introspectionEndpoint == "" {
+		return result, errors.New("the provider does not advertise an introspection_endpoint in its OIDC metadata")
+	}
+
+	log.Printf("Introspecting token at introspection endpoint: %s\n", introspectionEndpoint)
+
+	formData := url.Values{
+		"token": {token},
+	}
+
+	if tokenTypeHint != "" {
+		formData.Add("token_type_hint", tokenTypeHint)
+	}
+
+	var postError = FormPost(introspectionEndpoint, auth, formData, &result)
+
+	if postError != nil {
+		return result, postError
+	}
+
+	return result, nil
+}
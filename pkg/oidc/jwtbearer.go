@@ -0,0 +1,113 @@
+package oidc
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// How long a grant assertion is valid for, when the connection doesn't say
+const defaultAssertionLifetime = 300
+
+// JwtBearerAssertion describes the claims of the assertion presented for the jwt_bearer grant.
+// Issuer and subject default to the client_id, and the audience to the token endpoint
+type JwtBearerAssertion struct {
+	Issuer   string
+	Subject  string
+	Audience string
+	// Lifetime in seconds
+	Lifetime int
+	Claims   map[string]interface{}
+}
+
+// BuildJwtBearerAssertion creates and signs the assertion to exchange for a token
+// https://tools.ietf.org/html/rfc7523#section-3
+func BuildJwtBearerAssertion(assertion JwtBearerAssertion, clientId string, tokenEndpoint string, pemData string) (string, error) {
+	if pemData == "" {
+		return "", errors.New("no private key is configured to sign the jwt_bearer assertion")
+	}
+
+	jti, jtiErr := GenerateRandomStringURLSafe(24)
+
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	var now = time.Now()
+	var lifetime = assertion.Lifetime
+
+	if lifetime <= 0 {
+		lifetime = defaultAssertionLifetime
+	}
+
+	claims := jwt.MapClaims{}
+
+	// Extra claims go first, so they can't override the registered ones below
+	for key, value := range assertion.Claims {
+		claims[key] = value
+	}
+
+	claims["iss"] = firstNonEmpty(assertion.Issuer, clientId)
+	claims["sub"] = firstNonEmpty(assertion.Subject, clientId)
+	claims["aud"] = firstNonEmpty(assertion.Audience, tokenEndpoint)
+	claims["jti"] = jti
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Duration(lifetime) * time.Second).Unix()
+
+	return SignWithPrivateKey(claims, pemData)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// RequestWithJwtBearer mints a fresh assertion, and exchanges it for a token
+// https://tools.ietf.org/html/rfc7523#section-2.1
+func RequestWithJwtBearer(tokenEndpoint string, auth ClientAuthentication, assertion JwtBearerAssertion, scope string, resources []string) (TokenResultSet, error) {
+	var result TokenResultSet
+
+	signedAssertion, assertionErr := BuildJwtBearerAssertion(assertion, auth.ClientId, tokenEndpoint, auth.PrivateKey)
+
+	if assertionErr != nil {
+		return result, assertionErr
+	}
+
+	log.Printf("Requesting token with jwt_bearer grant: %s\n", tokenEndpoint)
+
+	formData := url.Values{
+		"grant_type": {JwtBearerGrantType},
+		"assertion":  {signedAssertion},
+	}
+
+	if scope != "" {
+		formData.Add("scope", scope)
+	}
+
+	addResources(formData, resources)
+
+	var postError = FormPost(tokenEndpoint, auth, formData, &result)
+
+	if postError != nil {
+		return result, postError
+	}
+
+	warnIfNotDPoPBound(auth, result.TokenType)
+
+	if bindingErr := verifyIfCertificateBound(auth, result.AccessToken); bindingErr != nil {
+		return result, bindingErr
+	}
+
+	result.ExpiresAt = AbsoluteExpiry(time.Now(), result.ExpiresIn)
+	result.TokenEndpoint = tokenEndpoint
+
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package oidc
+
+import (
+	"errors"
+	"net/url"
+)
+
+// BuildEndSessionRequest builds the URL that asks the provider to sign the user out, then send them back to us
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout
+func BuildEndSessionRequest(configuration WellKnownConfiguration, clientId string, idTokenHint string, postLogoutRedirectUri string, state string) (string, error) {
+	if configuration.EndSessionEndpoint == "" {
+		return "", errors.New("the provider does not advertise an end_session_endpoint in its OIDC metadata")
+	}
+
+	urlToBuild, urlErr := url.Parse(configuration.EndSessionEndpoint)
+
+	if urlErr != nil {
+		return "", urlErr
+	}
+
+	q := urlToBuild.Query()
+	q.Set("client_id", clientId)
+	q.Set("post_logout_redirect_uri", postLogoutRedirectUri)
+	q.Set("state", state)
+
+	if idTokenHint != "" {
+		q.Set("id_token_hint", idTokenHint)
+	}
+
+	urlToBuild.RawQuery = q.Encode()
+
+	return urlToBuild.String(), nil
+}
+
+// ValidateLogoutResponse checks the provider sent the user back from the logout request we made
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RedirectionAfterLogout
+func ValidateLogoutResponse(url *url.URL, state string) error {
+	if url.Query().Get("state") != state {
+		return errors.New(`oidc Error: state parameters don't match`)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/gookit/color"
+)
+
+// Endpoints the provider serves with mutual TLS, when they differ from the regular ones
+// https://tools.ietf.org/html/rfc8705#section-5
+type MtlsEndpointAliases struct {
+	TokenEndpoint                      string `json:"token_endpoint"`
+	RevocationEndpoint                 string `json:"revocation_endpoint"`
+	IntrospectionEndpoint              string `json:"introspection_endpoint"`
+	DeviceAuthorisationEndpoint        string `json:"device_authorization_endpoint"`
+	PushedAuthorisationRequestEndpoint string `json:"pushed_authorization_request_endpoint"`
+	UserInfoEndpoint                   string `json:"userinfo_endpoint"`
+}
+
+// UsesMutualTLS is true when the client presents a certificate to the provider
+func (auth ClientAuthentication) UsesMutualTLS() bool {
+	return auth.Certificate != ""
+}
+
+// httpClient builds a client which presents the client certificate, if one is configured
+func (auth ClientAuthentication) httpClient() (*http.Client, error) {
+	if !auth.UsesMutualTLS() {
+		return auth.Transport.httpClient(nil)
+	}
+
+	certificate, certificateErr := tls.X509KeyPair([]byte(auth.Certificate), []byte(auth.CertificateKey))
+
+	if certificateErr != nil {
+		return nil, fmt.Errorf("unable to load client certificate: %v", certificateErr)
+	}
+
+	return auth.Transport.httpClient([]tls.Certificate{certificate})
+}
+
+// WithMutualTLSEndpoints swaps the regular endpoints for their mTLS aliases, where the provider advertises them
+func (configuration WellKnownConfiguration) WithMutualTLSEndpoints() WellKnownConfiguration {
+	var aliases = configuration.MtlsEndpointAliases
+
+	if aliases.TokenEndpoint != "" {
+		configuration.TokenEndpoint = aliases.TokenEndpoint
+	}
+
+	if aliases.RevocationEndpoint != "" {
+		configuration.RevocationEndpoint = aliases.RevocationEndpoint
+	}
+
+	if aliases.IntrospectionEndpoint != "" {
+		configuration.IntrospectionEndpoint = aliases.IntrospectionEndpoint
+	}
+
+	if aliases.DeviceAuthorisationEndpoint != "" {
+		configuration.DeviceAuthorisationEndpoint = aliases.DeviceAuthorisationEndpoint
+	}
+
+	if aliases.PushedAuthorisationRequestEndpoint != "" {
+		configuration.PushedAuthorisationRequestEndpoint = aliases.PushedAuthorisationRequestEndpoint
+	}
+
+	if aliases.UserInfoEndpoint != "" {
+		configuration.UserInfoEndpoint = aliases.UserInfoEndpoint
+	}
+
+	return configuration
+}
+
+// ValidateClientCertificate checks that a PEM encoded certificate and key belong together
+func ValidateClientCertificate(certificatePem string, keyPem string) error {
+	_, err := tls.X509KeyPair([]byte(certificatePem), []byte(keyPem))
+
+	return err
+}
+
+// CertificateThumbprint is the base64url encoded SHA-256 hash of the DER encoded certificate
+// https://tools.ietf.org/html/rfc8705#section-3.1
+func CertificateThumbprint(certificatePem string) (string, error) {
+	block, _ := pem.Decode([]byte(certificatePem))
+
+	if block == nil {
+		return "", errors.New("unable to find a PEM block in the client certificate")
+	}
+
+	certificate, parseErr := x509.ParseCertificate(block.Bytes)
+
+	if parseErr != nil {
+		return "", parseErr
+	}
+
+	hash := sha256.Sum256(certificate.Raw)
+
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// VerifyCertificateBinding checks that a JWT access token is bound to the client certificate.
+// Opaque access tokens can't be checked locally, so they're skipped with a warning
+// https://tools.ietf.org/html/rfc8705#section-3
+func VerifyCertificateBinding(accessToken string, certificatePem string) error {
+	var claims = jwt.MapClaims{}
+
+	_, _, parseErr := jwt.NewParser().ParseUnverified(accessToken, claims)
+
+	if parseErr != nil {
+		log.Printf("%s", color.Yellow.Sprintf("The access token isn't a JWT, so its certificate binding can't be verified"))
+		return nil
+	}
+
+	confirmation, hasConfirmation := claims["cnf"].(map[string]interface{})
+
+	if !hasConfirmation {
+		log.Printf("%s", color.Yellow.Sprintf("The access token has no `cnf` claim, so it isn't bound to the client certificate"))
+		return nil
+	}
+
+	boundThumbprint, hasThumbprint := confirmation["x5t#S256"].(string)
+
+	if !hasThumbprint {
+		return errors.New("the access token's `cnf` claim has no `x5t#S256` certificate thumbprint")
+	}
+
+	thumbprint, thumbprintErr := CertificateThumbprint(certificatePem)
+
+	if thumbprintErr != nil {
+		return thumbprintErr
+	}
+
+	if boundThumbprint != thumbprint {
+		return fmt.Errorf("the access token is bound to a different certificate. expected x5t#S256: %s, got: %s", thumbprint, boundThumbprint)
+	}
+
+	log.Println("Access token is bound to the client certificate")
+
+	return nil
+}
+
+// verifyIfCertificateBound checks a new access token is bound to the client certificate, when the client authenticates with one
+func verifyIfCertificateBound(auth ClientAuthentication, accessToken string) error {
+	if !auth.UsesMutualTLS() {
+		return nil
+	}
+
+	return VerifyCertificateBinding(accessToken, auth.Certificate)
+}
@@ -0,0 +1,199 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Exit codes, so scripts can tell whether connecting again will help
+const ExitCodeError = 1
+const ExitCodeReconnect = 3
+const ExitCodeUnavailable = 4
+
+// OAuthError is an error response from one of the provider's endpoints
+// https://tools.ietf.org/html/rfc6749#section-5.2
+type OAuthError struct {
+	Endpoint    string `json:"-"`
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Uri         string `json:"error_uri"`
+	// The response body, when it isn't a JSON error
+	Body string `json:"-"`
+}
+
+// What the token endpoint's error codes mean, for when the provider doesn't describe them
+var tokenErrorHints = map[string]string{
+	"invalid_client":         "the provider couldn't authenticate the client. Check its credentials",
+	"invalid_grant":          "the code, refresh token or assertion is invalid, expired or revoked. Connect again",
+	"unsupported_grant_type": "the provider doesn't support this grant type",
+	// https://tools.ietf.org/html/rfc8707#section-2
+	"invalid_target": "the provider won't issue a token for the requested resource",
+	// Resource servers, such as the userinfo endpoint, respond with these
+	// https://tools.ietf.org/html/rfc6750#section-3.1
+	"invalid_token":      "the access token is invalid, expired or revoked. Connect again",
+	"insufficient_scope": "the access token doesn't have the scopes the request needs",
+}
+
+// The auth-param pairs of a WWW-Authenticate challenge, with quoted or token values
+var challengeParamPattern = regexp.MustCompile(`([A-Za-z0-9_-]+)=(?:"((?:[^"\\]|\\.)*)"|([^\s,]*))`)
+
+// A backslash escapes the character after it in a quoted value
+// https://tools.ietf.org/html/rfc7230#section-3.2.6
+var quotedPairPattern = regexp.MustCompile(`\\(.)`)
+
+func (err OAuthError) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("received error from %s. statusCode: %d, body: %s", err.Endpoint, err.StatusCode, err.Body)
+	}
+
+	var description = err.Description
+
+	if description == "" {
+		description = tokenErrorHints[err.Code]
+	}
+
+	if description == "" {
+		description = authorisationErrorHints[err.Code]
+	}
+
+	var message = fmt.Sprintf("received %s from %s (statusCode: %d)", err.Code, err.Endpoint, err.StatusCode)
+
+	if description != "" {
+		message = fmt.Sprintf("%s: %s", message, description)
+	}
+
+	if err.Uri != "" {
+		message = fmt.Sprintf("%s (see %s)", message, err.Uri)
+	}
+
+	return message
+}
+
+// NeedsReconnect reports whether the grant is no longer any good, so the user has to connect again
+func (err OAuthError) NeedsReconnect() bool {
+	return err.Code == "invalid_grant" || err.Code == "invalid_token"
+}
+
+// IsTemporary reports whether the same request may succeed later
+func (err OAuthError) IsTemporary() bool {
+	return err.StatusCode == http.StatusTooManyRequests ||
+		err.StatusCode >= 500 ||
+		err.Code == "temporarily_unavailable" ||
+		err.Code == "server_error"
+}
+
+// readOAuthError reads an error response. Providers don't always respond with JSON, so the body is kept if it isn't
+func readOAuthError(endpoint string, response *http.Response) OAuthError {
+	var oauthErr = OAuthError{
+		Endpoint:   endpoint,
+		StatusCode: response.StatusCode,
+	}
+
+	body, readErr := ioutil.ReadAll(response.Body)
+
+	if readErr != nil {
+		oauthErr.Body = fmt.Sprintf("unable to read the response: %v", readErr)
+		return oauthErr
+	}
+
+	if decodeErr := json.Unmarshal(body, &oauthErr); decodeErr != nil || oauthErr.Code == "" {
+		oauthErr.Code = ""
+		oauthErr.Body = strings.TrimSpace(string(body))
+	}
+
+	return oauthErr
+}
+
+// readBearerError reads an error response from a resource server. The error is in the WWW-Authenticate
+// challenge rather than the body, which is kept when the challenge doesn't have one
+// https://tools.ietf.org/html/rfc6750#section-3
+func readBearerError(endpoint string, response *http.Response, body []byte) OAuthError {
+	var oauthErr = OAuthError{
+		Endpoint:   endpoint,
+		StatusCode: response.StatusCode,
+	}
+
+	for _, challenge := range response.Header.Values("WWW-Authenticate") {
+		for _, param := range challengeParamPattern.FindAllStringSubmatch(challenge, -1) {
+			var value = param[3]
+
+			if value == "" {
+				value = quotedPairPattern.ReplaceAllString(param[2], "$1")
+			}
+
+			switch strings.ToLower(param[1]) {
+			case "error":
+				oauthErr.Code = value
+			case "error_description":
+				oauthErr.Description = value
+			case "error_uri":
+				oauthErr.Uri = value
+			}
+		}
+
+		if oauthErr.Code != "" {
+			return oauthErr
+		}
+	}
+
+	oauthErr.Body = strings.TrimSpace(string(body))
+
+	return oauthErr
+}
+
+// ExitCode chooses the exit code for an error: whether the user needs to connect again, or the provider
+// couldn't be reached and trying again later may work
+func ExitCode(err error) int {
+	var oauthErr OAuthError
+
+	if errors.As(err, &oauthErr) {
+		if oauthErr.NeedsReconnect() {
+			return ExitCodeReconnect
+		}
+
+		if oauthErr.IsTemporary() {
+			return ExitCodeUnavailable
+		}
+
+		return ExitCodeError
+	}
+
+	var authErr AuthorisationError
+
+	if errors.As(err, &authErr) && authErr.IsTemporary() {
+		return ExitCodeUnavailable
+	}
+
+	var netErr net.Error
+	var opErr *net.OpError
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ExitCodeUnavailable
+	}
+
+	return ExitCodeError
+}
+
+// ExitWithError ends xoauth with an exit code that tells scripts whether connecting again will help
+func ExitWithError(err error) {
+	log.Println(err)
+
+	switch ExitCode(err) {
+	case ExitCodeReconnect:
+		log.Println("Use `xoauth connect` to sign in again")
+	case ExitCodeUnavailable:
+		log.Println("The provider couldn't be reached. Try again later")
+	}
+
+	os.Exit(ExitCode(err))
+}
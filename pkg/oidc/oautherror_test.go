@@ -0,0 +1,99 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestReadBearerError(t *testing.T) {
+	var cases = []struct {
+		name                string
+		challenges          []string
+		body                string
+		expectedCode        string
+		expectedDescription string
+		expectedBody        string
+	}{
+		{
+			name:                "quoted values",
+			challenges:          []string{`Bearer realm="example", error="invalid_token", error_description="The access token expired"`},
+			expectedCode:        "invalid_token",
+			expectedDescription: "The access token expired",
+		},
+		{
+			name:                "escaped quotes",
+			challenges:          []string{`Bearer error="invalid_token", error_description="The \"token\" expired"`},
+			expectedCode:        "invalid_token",
+			expectedDescription: `The "token" expired`,
+		},
+		{
+			name:         "token values",
+			challenges:   []string{`Bearer error=insufficient_scope, scope="openid profile"`},
+			expectedCode: "insufficient_scope",
+		},
+		{
+			name:         "error in a later challenge",
+			challenges:   []string{`Basic realm="example"`, `DPoP algs="ES256", error="invalid_token"`},
+			expectedCode: "invalid_token",
+		},
+		{
+			name:         "no error in the challenge",
+			challenges:   []string{`Bearer realm="example"`},
+			body:         " Service Unavailable\n",
+			expectedBody: "Service Unavailable",
+		},
+	}
+
+	for _, c := range cases {
+		var response = &http.Response{StatusCode: http.StatusUnauthorized, Header: http.Header{}}
+
+		for _, challenge := range c.challenges {
+			response.Header.Add("WWW-Authenticate", challenge)
+		}
+
+		var oauthErr = readBearerError("https://example.com/userinfo", response, []byte(c.body))
+
+		if oauthErr.Code != c.expectedCode {
+			t.Errorf("%s: code = %q, expected %q", c.name, oauthErr.Code, c.expectedCode)
+		}
+
+		if oauthErr.Description != c.expectedDescription {
+			t.Errorf("%s: description = %q, expected %q", c.name, oauthErr.Description, c.expectedDescription)
+		}
+
+		if oauthErr.Body != c.expectedBody {
+			t.Errorf("%s: body = %q, expected %q", c.name, oauthErr.Body, c.expectedBody)
+		}
+
+		if oauthErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status code = %d, expected %d", c.name, oauthErr.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	var cases = []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"invalid_grant", OAuthError{StatusCode: 400, Code: "invalid_grant"}, ExitCodeReconnect},
+		{"invalid_token", OAuthError{StatusCode: 401, Code: "invalid_token"}, ExitCodeReconnect},
+		{"invalid_client", OAuthError{StatusCode: 401, Code: "invalid_client"}, ExitCodeError},
+		{"rate limited", OAuthError{StatusCode: 429}, ExitCodeUnavailable},
+		{"server error", OAuthError{StatusCode: 503}, ExitCodeUnavailable},
+		{"temporarily_unavailable", OAuthError{StatusCode: 400, Code: "temporarily_unavailable"}, ExitCodeUnavailable},
+		{"authorisation error", AuthorisationError{Code: "access_denied"}, ExitCodeError},
+		{"temporary authorisation error", AuthorisationError{Code: "temporarily_unavailable"}, ExitCodeUnavailable},
+		{"timeout", context.DeadlineExceeded, ExitCodeUnavailable},
+		{"anything else", errors.New("something went wrong"), ExitCodeError},
+	}
+
+	for _, c := range cases {
+		if actual := ExitCode(c.err); actual != c.expected {
+			t.Errorf("%s: ExitCode = %d, expected %d", c.name, actual, c.expected)
+		}
+	}
+}
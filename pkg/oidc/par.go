@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"errors"
+	"log"
+	"net/url"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc9126#section-2.2
+type PushedAuthorisationResult struct {
+	RequestUri string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+// PushAuthorisationRequest sends the authorisation request parameters over the back channel
+// https://datatracker.ietf.org/doc/html/rfc9126#section-2.1
+func PushAuthorisationRequest(parEndpoint string, auth ClientAuthentication, parameters url.Values) (PushedAuthorisationResult, error) {
+	var result PushedAuthorisationResult
+
+	if parEndpoint == "" {
+		return result, errors.New("the provider does not advertise a pushed_authorization_request_endpoint in its OIDC metadata")
+	}
+
+	log.Printf("Pushing authorisation request to: %s\n", parEndpoint)
+
+	var postError = FormPost(parEndpoint, auth, parameters, &result)
+
+	if postError != nil {
+		return result, postError
+	}
+
+	if result.RequestUri == "" {
+		return result, errors.New("no request_uri in the pushed authorisation response")
+	}
+
+	return result, nil
+}
+
+// BuildPushedAuthorisationRequest builds the front-channel URL, which only refers to the pushed request
+// https://datatracker.ietf.org/doc/html/rfc9126#section-4
+func BuildPushedAuthorisationRequest(configuration WellKnownConfiguration, clientId string, requestUri string) string {
+	q := url.Values{}
+	q.Add("client_id", clientId)
+	q.Add("request_uri", requestUri)
+
+	return BuildAuthorisationUrl(configuration, q)
+}
@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// AuthorisationDetails describe fine-grained permissions, such as a single payment, that a plain scope can't
+// https://datatracker.ietf.org/doc/html/rfc9396#section-2
+type AuthorisationDetails []map[string]interface{}
+
+// ParseAuthorisationDetails reads a JSON array of authorization_details objects, or a single object
+func ParseAuthorisationDetails(data []byte) (AuthorisationDetails, error) {
+	var details AuthorisationDetails
+	var trimmed = strings.TrimSpace(string(data))
+
+	if strings.HasPrefix(trimmed, "{") {
+		trimmed = "[" + trimmed + "]"
+	}
+
+	if decodeErr := json.Unmarshal([]byte(trimmed), &details); decodeErr != nil {
+		return nil, fmt.Errorf("authorization_details must be a JSON object, or an array of objects: %v", decodeErr)
+	}
+
+	if len(details) == 0 {
+		return nil, errors.New("authorization_details must contain at least one object")
+	}
+
+	// Every object must say what kind of authorisation it describes
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-2
+	for index, detail := range details {
+		if detailType, _ := detail["type"].(string); detailType == "" {
+			return nil, fmt.Errorf("authorization_details object %d has no type", index)
+		}
+	}
+
+	return details, nil
+}
+
+// Encode serialises the details for the authorization_details request parameter
+func (details AuthorisationDetails) Encode() (string, error) {
+	encoded, encodeErr := json.Marshal(details)
+
+	if encodeErr != nil {
+		return "", encodeErr
+	}
+
+	return string(encoded), nil
+}
+
+// addAuthorisationDetails adds the authorization_details parameter to a request, when there are any
+// https://datatracker.ietf.org/doc/html/rfc9396#section-6
+func addAuthorisationDetails(formData url.Values, details AuthorisationDetails) error {
+	if len(details) == 0 {
+		return nil
+	}
+
+	encoded, encodeErr := details.Encode()
+
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	formData.Set("authorization_details", encoded)
+
+	return nil
+}
+
+// WarnAboutUnsupportedTypes logs the types the provider doesn't advertise. Providers needn't advertise them all,
+// so it isn't an error
+// https://datatracker.ietf.org/doc/html/rfc9396#section-10
+func (details AuthorisationDetails) WarnAboutUnsupportedTypes(configuration WellKnownConfiguration) {
+	if len(configuration.AuthorisationDetailsTypesSupported) == 0 {
+		return
+	}
+
+	for _, detail := range details {
+		detailType, _ := detail["type"].(string)
+
+		if !containsString(configuration.AuthorisationDetailsTypesSupported, detailType) {
+			log.Printf("The provider doesn't advertise support for the %q authorization_details type", detailType)
+		}
+	}
+}
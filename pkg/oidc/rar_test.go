@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseAuthorisationDetails(t *testing.T) {
+	var cases = []struct {
+		name          string
+		data          string
+		expectedCount int
+		valid         bool
+	}{
+		{"array", `[{"type": "payment_initiation", "instructedAmount": {"currency": "NZD", "amount": "12.50"}}]`, 1, true},
+		{"several objects", `[{"type": "account_information"}, {"type": "payment_initiation"}]`, 2, true},
+		{"single object", ` {"type": "account_information"} `, 1, true},
+		{"empty array", `[]`, 0, false},
+		{"object without a type", `[{"type": "account_information"}, {"actions": ["read"]}]`, 0, false},
+		{"empty type", `{"type": ""}`, 0, false},
+		{"not an object", `"payment_initiation"`, 0, false},
+		{"invalid JSON", `[{"type": }]`, 0, false},
+	}
+
+	for _, c := range cases {
+		details, parseErr := ParseAuthorisationDetails([]byte(c.data))
+
+		if (parseErr == nil) != c.valid {
+			t.Errorf("%s: ParseAuthorisationDetails = %v, expected valid: %t", c.name, parseErr, c.valid)
+			continue
+		}
+
+		if len(details) != c.expectedCount {
+			t.Errorf("%s: got %d objects, expected %d", c.name, len(details), c.expectedCount)
+		}
+	}
+}
+
+func TestAddAuthorisationDetails(t *testing.T) {
+	var formData = url.Values{}
+
+	if addErr := addAuthorisationDetails(formData, nil); addErr != nil || formData.Get("authorization_details") != "" {
+		t.Errorf("no details: got %q, %v", formData.Get("authorization_details"), addErr)
+	}
+
+	details, _ := ParseAuthorisationDetails([]byte(`{"type": "account_information"}`))
+
+	if addErr := addAuthorisationDetails(formData, details); addErr != nil {
+		t.Fatal(addErr)
+	}
+
+	if encoded := formData.Get("authorization_details"); encoded != `[{"type":"account_information"}]` {
+		t.Errorf("authorization_details = %q, expected an array with the single object", encoded)
+	}
+}
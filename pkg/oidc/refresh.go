@@ -11,30 +11,48 @@ type RefreshResult struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn int `json:"expires_in"`
 	TokenType string `json:"token_type"`
+	// Providers may issue a new ID token, and say which scopes were granted when they differ from those requested
+	// https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse
+	IdentityToken string `json:"id_token"`
+	Scope string `json:"scope"`
+	// https://datatracker.ietf.org/doc/html/rfc9396#section-7
+	AuthorisationDetails AuthorisationDetails `json:"authorization_details,omitempty"`
 }
 
 
-func RefreshToken(authority string, clientId string, clientSecret string, refreshToken string) (RefreshResult, error) {
+// RefreshToken uses the refresh token to get a new access token. Given a resource, the access token
+// is restricted to that resource, and given a scope, to fewer scopes than were originally granted
+// https://tools.ietf.org/html/rfc6749#section-6
+// https://datatracker.ietf.org/doc/html/rfc8707#section-2.2
+func RefreshToken(tokenEndpoint string, auth ClientAuthentication, refreshToken string, resource string, scope string) (RefreshResult, error) {
 	var result RefreshResult
 
-	var metadata, metadataErr = GetMetadata(authority)
-
-	if metadataErr != nil {
-		return result, metadataErr
-	}
-
-	log.Printf("Exchanging refresh_token at token endpoint: %s\n", metadata.TokenEndpoint)
+	log.Printf("Exchanging refresh_token at token endpoint: %s\n", tokenEndpoint)
 
 	formData := url.Values{
 		"grant_type": {"refresh_token"},
 		"refresh_token": {refreshToken},
 	}
 
-	var postError = FormPost(metadata.TokenEndpoint, clientId, clientSecret, formData, &result)
+	if resource != "" {
+		formData.Add("resource", resource)
+	}
+
+	if scope != "" {
+		formData.Add("scope", scope)
+	}
+
+	var postError = FormPost(tokenEndpoint, auth, formData, &result)
 
 	if postError != nil {
 		return result, postError
 	}
 
+	warnIfNotDPoPBound(auth, result.TokenType)
+
+	if bindingErr := verifyIfCertificateBound(auth, result.AccessToken); bindingErr != nil {
+		return result, bindingErr
+	}
+
 	return result, nil
 }
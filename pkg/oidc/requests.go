@@ -0,0 +1,192 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// How long a request to the provider may take, when it isn't configured
+const DefaultRequestTimeout = 30 * time.Second
+
+// How many times a request is retried while the provider is rate limiting or unavailable, when it isn't configured
+const DefaultMaxRetries = 3
+
+// The first retry waits around this long, and each one after that around twice as long as the last
+const retryBaseDelay = 500 * time.Millisecond
+
+// The longest xoauth will wait to retry. A longer Retry-After is reported as an error instead
+const maxRetryDelay = 2 * time.Minute
+
+type RequestOptions struct {
+	// How long a single request may take, including reading the response. No limit when zero
+	Timeout time.Duration
+	// How many times to retry a request that was rate limited, or failed with a server error
+	MaxRetries int
+	// Cancels requests in flight, and retries that are waiting
+	Context context.Context
+	// Given on the command line, these take precedence over each connection's saved transport options
+	Transport TransportOptions
+}
+
+// Seeded, so separate runs don't all retry at the same moments
+var retryJitter = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+var requestOptions = RequestOptions{
+	Timeout:    DefaultRequestTimeout,
+	MaxRetries: DefaultMaxRetries,
+	Context:    context.Background(),
+}
+
+func ConfigureRequests(options RequestOptions) {
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+
+	requestOptions = options
+}
+
+// RequestContext is cancelled when the user interrupts xoauth, so anything waiting can stop
+func RequestContext() context.Context {
+	return requestOptions.Context
+}
+
+// Grants whose code or assertion is spent by the first request the provider handles. If that request then fails,
+// retrying it gets invalid_grant instead of the real error, so they're never retried. A jwt_bearer assertion is
+// signed once, with one jti, which the provider may refuse to see twice
+// https://tools.ietf.org/html/rfc6749#section-4.1.2
+// https://tools.ietf.org/html/rfc7523#section-3
+var oneTimeGrantTypes = []string{AuthorisationCode, DeviceCodeGrantType, JwtBearerGrantType}
+
+// isReplaySafe reports whether a form can be sent to the provider again if the first attempt fails
+func isReplaySafe(formData url.Values) bool {
+	var grantType = formData.Get("grant_type")
+
+	for _, oneTimeGrantType := range oneTimeGrantTypes {
+		if grantType == oneTimeGrantType {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isRetryable reports whether the provider is rate limiting or unavailable, so the request may succeed later
+func isRetryable(response *http.Response) bool {
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+// retryDelay is how long to wait before retrying. The provider's Retry-After is honoured, otherwise the
+// delay backs off exponentially, with jitter so many clients don't retry at once. It's false when the
+// provider wants xoauth to wait for longer than it's willing to
+// https://tools.ietf.org/html/rfc7231#section-7.1.3
+func retryDelay(response *http.Response, retry int, now time.Time) (time.Duration, bool) {
+	var retryAfter = response.Header.Get("Retry-After")
+
+	if retryAfter != "" {
+		var delay time.Duration
+
+		if seconds, parseErr := strconv.Atoi(retryAfter); parseErr == nil {
+			delay = time.Duration(seconds) * time.Second
+		} else if date, dateErr := http.ParseTime(retryAfter); dateErr == nil {
+			delay = date.Sub(now)
+		}
+
+		if delay < 0 {
+			delay = 0
+		}
+
+		return delay, delay <= maxRetryDelay
+	}
+
+	var backoff = retryBaseDelay << uint(retry)
+
+	if backoff > maxRetryDelay {
+		backoff = maxRetryDelay
+	}
+
+	return backoff/2 + time.Duration(retryJitter.Int63n(int64(backoff/2)+1)), true
+}
+
+// waitToRetry sleeps for the delay, unless the requests are cancelled first
+func waitToRetry(delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-requestOptions.Context.Done():
+		return requestOptions.Context.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sendRequest sends a request to the provider, waiting and trying again while it's rate limiting or unavailable.
+// With DPoP, the request is sent once more with the server's nonce if it asks for one, and the nonce is kept
+// for the next request to the endpoint. The request is rebuilt every time, so DPoP proofs and client assertions
+// aren't replayed, but the rest of the form, including any grant assertion, is sent again as it is.
+// Requests that aren't replay safe are only retried with a DPoP nonce, which the provider asks for before handling them.
+// The caller is responsible for closing the response body
+// https://datatracker.ietf.org/doc/html/rfc9449#section-8
+func sendRequest(client *http.Client, endpoint string, useDPoP bool, replaySafe bool, buildRequest func(dpopNonce string) (*http.Request, error)) (*http.Response, error) {
+	var dpopNonce = dpopNonces[endpoint]
+	var retries = 0
+	var retriedWithNonce = false
+
+	for {
+		request, requestBuildErr := buildRequest(dpopNonce)
+
+		if requestBuildErr != nil {
+			return nil, requestBuildErr
+		}
+
+		response, responseErr := client.Do(request)
+
+		if responseErr != nil {
+			return nil, fmt.Errorf("Error sending %s request to %s %w", request.Method, endpoint, responseErr)
+		}
+
+		// Wait and try again while the provider is rate limiting or unavailable
+		if replaySafe && isRetryable(response) && retries < requestOptions.MaxRetries {
+			delay, shouldRetry := retryDelay(response, retries, time.Now())
+
+			if shouldRetry {
+				response.Body.Close()
+				retries++
+
+				log.Printf("%s responded with %d, retrying in %v\n", endpoint, response.StatusCode, delay.Round(time.Millisecond))
+
+				if waitErr := waitToRetry(delay); waitErr != nil {
+					return nil, waitErr
+				}
+
+				continue
+			}
+		}
+
+		if !useDPoP {
+			return response, nil
+		}
+
+		var serverNonce = response.Header.Get("DPoP-Nonce")
+
+		if serverNonce != "" {
+			dpopNonces[endpoint] = serverNonce
+		}
+
+		if retriedWithNonce || serverNonce == "" || !requiresDPoPNonce(response) {
+			return response, nil
+		}
+
+		log.Println("Retrying with the server's DPoP nonce")
+
+		response.Body.Close()
+		dpopNonce = serverNonce
+		retriedWithNonce = true
+	}
+}
@@ -0,0 +1,95 @@
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	var cases = []struct {
+		statusCode int
+		expected   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	for _, c := range cases {
+		if actual := isRetryable(&http.Response{StatusCode: c.statusCode}); actual != c.expected {
+			t.Errorf("isRetryable(%d) = %t, expected %t", c.statusCode, actual, c.expected)
+		}
+	}
+}
+
+func TestIsReplaySafe(t *testing.T) {
+	var cases = []struct {
+		grantType string
+		expected  bool
+	}{
+		// Requests to other endpoints, such as revocation and PAR, have no grant type
+		{"", true},
+		{"client_credentials", true},
+		{"refresh_token", true},
+		{TokenExchangeGrantType, true},
+		{AuthorisationCode, false},
+		{DeviceCodeGrantType, false},
+		{JwtBearerGrantType, false},
+	}
+
+	for _, c := range cases {
+		var formData = url.Values{}
+
+		if c.grantType != "" {
+			formData.Set("grant_type", c.grantType)
+		}
+
+		if actual := isReplaySafe(formData); actual != c.expected {
+			t.Errorf("isReplaySafe(%q) = %t, expected %t", c.grantType, actual, c.expected)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	var now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var cases = []struct {
+		name          string
+		retryAfter    string
+		retry         int
+		minimum       time.Duration
+		maximum       time.Duration
+		expectedRetry bool
+	}{
+		{"seconds", "5", 0, 5 * time.Second, 5 * time.Second, true},
+		{"date", now.Add(10 * time.Second).Format(http.TimeFormat), 0, 10 * time.Second, 10 * time.Second, true},
+		{"date in the past", now.Add(-time.Minute).Format(http.TimeFormat), 0, 0, 0, true},
+		{"too long", "3600", 0, time.Hour, time.Hour, false},
+		{"first backoff", "", 0, retryBaseDelay / 2, retryBaseDelay, true},
+		{"third backoff", "", 2, 2 * retryBaseDelay, 4 * retryBaseDelay, true},
+		{"capped backoff", "", 20, maxRetryDelay / 2, maxRetryDelay, true},
+	}
+
+	for _, c := range cases {
+		var response = &http.Response{Header: http.Header{}}
+
+		if c.retryAfter != "" {
+			response.Header.Set("Retry-After", c.retryAfter)
+		}
+
+		delay, shouldRetry := retryDelay(response, c.retry, now)
+
+		if shouldRetry != c.expectedRetry {
+			t.Errorf("%s: shouldRetry = %t, expected %t", c.name, shouldRetry, c.expectedRetry)
+		}
+
+		if delay < c.minimum || delay > c.maximum {
+			t.Errorf("%s: delay = %v, expected between %v and %v", c.name, delay, c.minimum, c.maximum)
+		}
+	}
+}
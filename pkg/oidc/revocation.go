@@ -0,0 +1,42 @@
+package oidc
+
+import (
+	"errors"
+	"log"
+	"net/url"
+)
+
+// https://tools.ietf.org/html/rfc7009#section-2.1
+const RefreshTokenHint = "refresh_token"
+const AccessTokenHint = "access_token"
+
+// RevokeToken asks the provider to invalidate a token
+// https://tools.ietf.org/html/rfc7009#section-2
+func RevokeToken(revocationEndpoint string, auth ClientAuthentication, token string, tokenTypeHint string) error {
+	if revocationEndpoint == "" {
+		return errors.New("the provider does not advertise a revocation_endpoint in its OIDC metadata")
+	}
+
+	log.Printf("Revoking %s at revocation endpoint: %s\n", tokenTypeHint, revocationEndpoint)
+
+	formData := url.Values{
+		"token":           {token},
+		"token_type_hint": {tokenTypeHint},
+	}
+
+	response, responseErr := postForm(revocationEndpoint, auth, formData)
+
+	if responseErr != nil {
+		return responseErr
+	}
+
+	defer response.Body.Close()
+
+	// The provider responds with a 200 for tokens that are invalid or already revoked, too
+	// https://tools.ietf.org/html/rfc7009#section-2.2
+	if response.StatusCode != 200 {
+		return readOAuthError(revocationEndpoint, response)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package oidc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gookit/color"
+)
+
+// TransportOptions control how xoauth connects to the provider
+type TransportOptions struct {
+	// An HTTP(S) proxy URL. When empty, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used
+	Proxy string
+	// A PEM file of CA certificates to trust as well as the system's, such as an internal CA
+	CACertificateFile string
+	// The lowest TLS version to negotiate: 1.0, 1.1, 1.2 or 1.3. When empty, Go's default
+	MinTLSVersion string
+	// Don't verify the provider's certificate. Only for local test servers
+	InsecureSkipVerify bool
+}
+
+// A client is built for every request, but the warning only needs to be seen once
+var warnedInsecure = false
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion reads a TLS version such as 1.2
+func ParseTLSVersion(version string) (uint16, error) {
+	parsed, ok := tlsVersions[version]
+
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q. Use 1.0, 1.1, 1.2 or 1.3", version)
+	}
+
+	return parsed, nil
+}
+
+// Merge combines the global options with a connection's. The global options are given on the command line,
+// so they take precedence over the connection's saved ones
+func (options TransportOptions) Merge(global TransportOptions) TransportOptions {
+	if global.Proxy != "" {
+		options.Proxy = global.Proxy
+	}
+
+	if global.CACertificateFile != "" {
+		options.CACertificateFile = global.CACertificateFile
+	}
+
+	if global.MinTLSVersion != "" {
+		options.MinTLSVersion = global.MinTLSVersion
+	}
+
+	options.InsecureSkipVerify = options.InsecureSkipVerify || global.InsecureSkipVerify
+
+	return options
+}
+
+// httpClient builds a client with the connection's transport options, and the global ones. Client certificates
+// are presented to the provider for mutual TLS
+// cacheKey identifies the options a document is fetched with, so a copy fetched through one proxy
+// or CA bundle isn't served when xoauth is using another
+func (options TransportOptions) cacheKey() string {
+	var merged = options.Merge(requestOptions.Transport)
+
+	return fmt.Sprintf("proxy=%s ca=%s tls=%s insecure=%t", merged.Proxy, merged.CACertificateFile, merged.MinTLSVersion, merged.InsecureSkipVerify)
+}
+
+func (options TransportOptions) httpClient(certificates []tls.Certificate) (*http.Client, error) {
+	var merged = options.Merge(requestOptions.Transport)
+
+	tlsConfig := &tls.Config{
+		Certificates:       certificates,
+		InsecureSkipVerify: merged.InsecureSkipVerify,
+	}
+
+	if merged.InsecureSkipVerify && !warnedInsecure {
+		warnedInsecure = true
+		log.Printf("%s", color.Red.Sprintf("Not verifying the provider's TLS certificate. Only do this with local test servers"))
+	}
+
+	if merged.MinTLSVersion != "" {
+		version, versionErr := ParseTLSVersion(merged.MinTLSVersion)
+
+		if versionErr != nil {
+			return nil, versionErr
+		}
+
+		tlsConfig.MinVersion = version
+	}
+
+	if merged.CACertificateFile != "" {
+		roots, rootsErr := x509.SystemCertPool()
+
+		// The system pool isn't available on every platform
+		if rootsErr != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+
+		certificates, readErr := ioutil.ReadFile(merged.CACertificateFile)
+
+		if readErr != nil {
+			return nil, fmt.Errorf("unable to read CA certificates: %v", readErr)
+		}
+
+		if !roots.AppendCertsFromPEM(certificates) {
+			return nil, fmt.Errorf("no PEM encoded CA certificates found in %s", merged.CACertificateFile)
+		}
+
+		tlsConfig.RootCAs = roots
+	}
+
+	var proxy = http.ProxyFromEnvironment
+
+	if merged.Proxy != "" {
+		proxyUrl, parseErr := url.Parse(merged.Proxy)
+
+		if parseErr != nil || proxyUrl.Host == "" {
+			return nil, fmt.Errorf("the proxy %q must be a URL, such as http://proxy.example.com:8080", merged.Proxy)
+		}
+
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	// Keep the default transport's connection timeouts and HTTP/2 support
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   requestOptions.Timeout,
+		Transport: transport,
+	}, nil
+}
+
+// Validate checks that the proxy is a URL, the CA certificates can be read and the TLS version is known
+func (options TransportOptions) Validate() error {
+	_, clientErr := options.httpClient(nil)
+
+	return clientErr
+}
@@ -0,0 +1,133 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// RequestUserInfo fetches the claims about the user the access token was issued for.
+// The provider may respond with plain JSON, or with a JWT it has signed
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func RequestUserInfo(configuration WellKnownConfiguration, auth ClientAuthentication, accessToken string, tokenType string, allowedAlgorithms []string) (map[string]interface{}, error) {
+	var endpoint = configuration.UserInfoEndpoint
+
+	if endpoint == "" {
+		return nil, errors.New("the provider does not advertise a userinfo_endpoint in its OIDC metadata")
+	}
+
+	if IsOffline() {
+		return nil, fmt.Errorf("xoauth is in offline mode, so can't send a request to %s", endpoint)
+	}
+
+	client, clientErr := auth.httpClient()
+
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	log.Printf("Requesting user info from: %s\n", endpoint)
+
+	// DPoP-bound tokens are sent with a proof for this request, and may need the resource server's nonce
+	// https://datatracker.ietf.org/doc/html/rfc9449#section-7.1
+	var useDPoP = auth.UsesDPoP() && strings.EqualFold(tokenType, DPoPTokenType)
+
+	response, responseErr := sendRequest(client, endpoint, useDPoP, true, func(dpopNonce string) (*http.Request, error) {
+		request, requestBuildErr := http.NewRequestWithContext(requestOptions.Context, "GET", endpoint, nil)
+
+		if requestBuildErr != nil {
+			return nil, requestBuildErr
+		}
+
+		request.Header.Add("Accept", "application/json, application/jwt")
+
+		if !useDPoP {
+			request.Header.Add("Authorization", "Bearer "+accessToken)
+			return request, nil
+		}
+
+		proof, proofErr := BuildDPoPProof(auth.DPoPKey, "GET", endpoint, dpopNonce, accessToken)
+
+		if proofErr != nil {
+			return nil, proofErr
+		}
+
+		request.Header.Add("Authorization", DPoPTokenType+" "+accessToken)
+		request.Header.Add("DPoP", proof)
+
+		return request, nil
+	})
+
+	if responseErr != nil {
+		return nil, responseErr
+	}
+
+	defer response.Body.Close()
+
+	body, readErr := ioutil.ReadAll(response.Body)
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if response.StatusCode != 200 {
+		return nil, readBearerError(endpoint, response, body)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+
+	if mediaType == "application/jwt" {
+		return validateSignedUserInfo(strings.TrimSpace(string(body)), configuration, auth.ClientId, allowedAlgorithms)
+	}
+
+	var claims map[string]interface{}
+
+	if decodeErr := json.Unmarshal(body, &claims); decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode user info %v", decodeErr)
+	}
+
+	return claims, nil
+}
+
+// A signed userinfo response must come from the provider, and be for this client
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+func validateSignedUserInfo(userInfo string, configuration WellKnownConfiguration, clientId string, allowedAlgorithms []string) (map[string]interface{}, error) {
+	if strings.Count(userInfo, ".") != 2 {
+		return nil, errors.New("the provider returned an encrypted userinfo response, which xoauth doesn't support")
+	}
+
+	var algorithms = AllowedAlgorithms(allowedAlgorithms, configuration.UserInfoSigningAlgValuesSupported)
+
+	_, claims, parseErr := parseSignedToken(userInfo, configuration, algorithms, jwt.WithLeeway(clockTolerance))
+
+	if parseErr != nil {
+		return nil, fmt.Errorf("invalid signed user info: %v", parseErr)
+	}
+
+	if audErr := checkAudience(claims, clientId); audErr != nil {
+		return nil, fmt.Errorf("invalid signed user info: %v", audErr)
+	}
+
+	return claims, nil
+}
+
+// UnverifiedClaims reads the claims of a JWT without checking its signature. Only use it on tokens
+// that were validated when they were issued, such as a stored ID token
+func UnverifiedClaims(token string) (jwt.MapClaims, error) {
+	var claims = jwt.MapClaims{}
+
+	_, _, parseErr := new(jwt.Parser).ParseUnverified(token, claims)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	return claims, nil
+}
@@ -1,79 +1,350 @@
 package oidc
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go/v4"
 	"github.com/lestrrat-go/jwx/jwk"
 )
 
-func lookUpKey(keyId string, keys *jwk.Set) (interface{}, error) {
+// The algorithm ID tokens are signed with when the provider doesn't say
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+const DefaultSigningAlgorithm = "RS256"
 
-	if key := keys.LookupKeyID(keyId); len(key) == 1 {
-		var rawKey interface{}
+// How much clock skew is allowed when checking the times in ID tokens, access tokens and signed userinfo
+const clockTolerance = 300 * time.Second
 
-		if err := key[0].Raw(&rawKey); err != nil {
-			return rawKey, err
+// A public key from the provider's JWKS
+type signingKey struct {
+	KeyId     string
+	KeyType   string
+	Algorithm string
+	Use       string
+	PublicKey interface{}
+}
+
+type jwksDocument struct {
+	Keys []json.RawMessage `json:"keys"`
+}
+
+type jwkHeader struct {
+	KeyId     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+}
+
+// parseKeySet reads the keys in a JWKS. Keys that can't be used are skipped rather than failing the whole set,
+// so one unusual key doesn't stop tokens signed with the others from being validated
+// https://tools.ietf.org/html/rfc7517#section-5
+func parseKeySet(body []byte) ([]signingKey, error) {
+	var document jwksDocument
+
+	if decodeErr := json.Unmarshal(body, &document); decodeErr != nil {
+		return nil, fmt.Errorf("unable to read the provider's signing keys: %v", decodeErr)
+	}
+
+	var keys []signingKey
+
+	for _, rawKey := range document.Keys {
+		var header jwkHeader
+
+		if json.Unmarshal(rawKey, &header) != nil {
+			continue
+		}
+
+		publicKey, keyErr := parsePublicKey(header, rawKey)
+
+		if keyErr != nil {
+			log.Printf("Skipping signing key %q: %v", header.KeyId, keyErr)
+			continue
 		}
 
-		return rawKey, nil
+		keys = append(keys, signingKey{
+			KeyId:     header.KeyId,
+			KeyType:   header.KeyType,
+			Algorithm: header.Algorithm,
+			Use:       header.Use,
+			PublicKey: publicKey,
+		})
 	}
 
-	return nil, errors.New(fmt.Sprintf("unable to find key with id %s", keyId))
+	return keys, nil
 }
 
-func getKeyValidatorFunc(keys *jwk.Set) func(token *jwt.Token) (interface{}, error) {
+func parsePublicKey(header jwkHeader, rawKey []byte) (interface{}, error) {
+	// jwx doesn't read octet key pairs, so Ed25519 keys are decoded here
+	// https://tools.ietf.org/html/rfc8037#section-2
+	if header.KeyType == "OKP" {
+		if header.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", header.Curve)
+		}
+
+		x, decodeErr := base64.RawURLEncoding.DecodeString(header.X)
+
+		if decodeErr != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	if header.KeyType != "RSA" && header.KeyType != "EC" {
+		return nil, fmt.Errorf("unsupported key type %q", header.KeyType)
+	}
+
+	key, parseErr := jwk.ParseKey(rawKey)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	var publicKey interface{}
+
+	if rawErr := key.Raw(&publicKey); rawErr != nil {
+		return nil, rawErr
+	}
+
+	return publicKey, nil
+}
+
+// ECDSA algorithms each sign with one curve
+// https://tools.ietf.org/html/rfc7518#section-3.4
+var curvesForAlgorithms = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+// checkCurve refuses an EC key on a different curve to the one the token's algorithm uses
+func checkCurve(key signingKey, alg string) error {
+	expectedCurve, isEcdsa := curvesForAlgorithms[alg]
+
+	if !isEcdsa {
+		return nil
+	}
+
+	publicKey, isEcKey := key.PublicKey.(*ecdsa.PublicKey)
+
+	if !isEcKey {
+		return fmt.Errorf("key %s isn't an EC key", key.KeyId)
+	}
+
+	if curve := publicKey.Curve.Params().Name; curve != expectedCurve {
+		return fmt.Errorf("key %s is on the %s curve, but %s tokens must be signed with a %s key", key.KeyId, curve, alg, expectedCurve)
+	}
+
+	return nil
+}
+
+// keyTypeForAlgorithm is the kind of key each signing algorithm uses
+// https://tools.ietf.org/html/rfc7518#section-3.1
+func keyTypeForAlgorithm(alg string) string {
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return "RSA"
+	case strings.HasPrefix(alg, "ES"):
+		return "EC"
+	case alg == "EdDSA":
+		return "OKP"
+	}
+
+	return ""
+}
+
+// IsSupportedSigningAlgorithm reports whether xoauth can verify tokens signed with the algorithm
+func IsSupportedSigningAlgorithm(alg string) bool {
+	return alg == EdDSA.Alg() || (isAsymmetricAlgorithm(alg) && jwt.GetSigningMethod(alg) != nil)
+}
+
+// isAsymmetricAlgorithm rules out unsigned tokens, and HMAC algorithms which could be used to
+// forge a signature with the provider's public key as the secret
+func isAsymmetricAlgorithm(alg string) bool {
+	return keyTypeForAlgorithm(alg) != ""
+}
+
+// AllowedAlgorithms picks the algorithms a token may be signed with: those configured for the connection,
+// otherwise the ones the provider advertises for that kind of token, or RS256. Unsigned and HMAC tokens are never accepted
+func AllowedAlgorithms(configured []string, advertised []string) []string {
+	var candidates = configured
+
+	if len(candidates) == 0 {
+		candidates = advertised
+	}
+
+	if len(candidates) == 0 {
+		candidates = []string{DefaultSigningAlgorithm}
+	}
+
+	var allowed []string
+
+	for _, alg := range candidates {
+		if IsSupportedSigningAlgorithm(alg) {
+			allowed = append(allowed, alg)
+		}
+	}
+
+	return allowed
+}
+
+func lookUpKey(token *jwt.Token, keys []signingKey) (interface{}, error) {
+	var alg = token.Method.Alg()
+	var keyType = keyTypeForAlgorithm(alg)
+	var keyId, hasKeyId = token.Header["kid"].(string)
+	var candidates []signingKey
+
+	for _, key := range keys {
+		if key.KeyType != keyType || key.Use == "enc" {
+			continue
+		}
+
+		if key.Algorithm != "" && key.Algorithm != alg {
+			continue
+		}
+
+		if hasKeyId && key.KeyId != keyId {
+			continue
+		}
+
+		if curveErr := checkCurve(key, alg); curveErr != nil {
+			if hasKeyId {
+				return nil, curveErr
+			}
+
+			continue
+		}
+
+		candidates = append(candidates, key)
+	}
+
+	// Without a kid, the key is only unambiguous when there's just one of the right type
+	// https://openid.net/specs/openid-connect-core-1_0.html#Signing
+	if len(candidates) == 1 {
+		return candidates[0].PublicKey, nil
+	}
+
+	if !hasKeyId && len(candidates) > 1 {
+		return nil, fmt.Errorf("the token has no kid, and there are %d %s keys it could be signed with", len(candidates), keyType)
+	}
+
+	return nil, fmt.Errorf("unable to find a %s key with id %s", keyType, keyId)
+}
+
+// getJwks reads the provider's signing keys. The key set is cached with no expiry, because
+// providers publish new keys under a new kid: it's only fetched again when a token names a kid it doesn't contain
+func getJwks(jwksUri string, refetch bool, transport TransportOptions) ([]signingKey, error) {
+	body, fetchErr := getUnexpiringJsonDocument("jwks:"+jwksUri, jwksUri, refetch, transport)
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	if body == nil && IsOffline() {
+		return nil, fmt.Errorf("%v: no signing keys cached from %s", errOffline, jwksUri)
+	}
+
+	if body == nil {
+		return nil, fmt.Errorf("no signing keys found at %s", jwksUri)
+	}
+
+	return parseKeySet(body)
+}
+
+func getKeyValidatorFunc(keys []signingKey, jwksUri string, transport TransportOptions, allowedAlgorithms []string) func(token *jwt.Token) (interface{}, error) {
 	return func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		var alg = token.Method.Alg()
+
+		if !isAsymmetricAlgorithm(alg) {
+			return nil, fmt.Errorf("refusing a token signed with %s, which isn't a public key algorithm", alg)
 		}
 
-		keyId, keyOk := token.Header["kid"].(string)
+		var allowed = false
+
+		for _, allowedAlg := range allowedAlgorithms {
+			if allowedAlg == alg {
+				allowed = true
+			}
+		}
 
-		if !keyOk {
-			return nil, errors.New("unable to parse `kid` as string")
+		if !allowed {
+			return nil, fmt.Errorf("the token is signed with %s, but only %s are allowed", alg, strings.Join(allowedAlgorithms, ", "))
 		}
 
-		var rsaPubKey, keyLookupErr = lookUpKey(keyId, keys)
+		keyId, _ := token.Header["kid"].(string)
+
+		var publicKey, keyLookupErr = lookUpKey(token, keys)
+
+		if keyLookupErr != nil && !IsOffline() {
+			log.Printf("Key %s isn't in the cached key set, fetching it again", keyId)
+
+			refreshedKeys, jwksErr := getJwks(jwksUri, true, transport)
+
+			if jwksErr != nil {
+				return nil, jwksErr
+			}
+
+			publicKey, keyLookupErr = lookUpKey(token, refreshedKeys)
+		}
 
 		if keyLookupErr != nil {
-			return nil, fmt.Errorf("couldn't find key with id: %s", keyId)
+			return nil, keyLookupErr
 		}
 
-		log.Printf("Using public key: %s", keyId)
+		log.Printf("Using %s public key: %s", alg, keyId)
 
-		return rsaPubKey, nil
+		return publicKey, nil
 	}
 }
 
-func ValidateToken(tokenString string, configuration WellKnownConfiguration, clientId string) (interface{}, error) {
-	// Allow up to five minutes of clock skew
-	var clockToleranceSeconds = 300 * time.Second
-
-	keys, jwksError := jwk.FetchHTTP(configuration.JwksUri)
+// parseSignedToken verifies a JWT's signature against the provider's keys, and checks its issuer and lifetime
+func parseSignedToken(tokenString string, configuration WellKnownConfiguration, allowedAlgorithms []string, options ...jwt.ParserOption) (*jwt.Token, jwt.MapClaims, error) {
+	keys, jwksError := getJwks(configuration.JwksUri, false, configuration.transport)
 
 	if jwksError != nil {
-		return nil, errors.New("expecting JWT header to have string kid")
+		return nil, nil, jwksError
 	}
 
-	token, tokenErr := jwt.Parse(tokenString, getKeyValidatorFunc(keys), jwt.WithLeeway(clockToleranceSeconds), jwt.WithoutAudienceValidation(), jwt.WithIssuer(configuration.Issuer))
+	options = append(options, jwt.WithoutAudienceValidation(), jwt.WithIssuer(configuration.Issuer))
+
+	var keyFunc = getKeyValidatorFunc(keys, configuration.JwksUri, configuration.transport, allowedAlgorithms)
+	var keyErr error
+
+	// jwt-go doesn't keep the key function's error, which says why the token was refused
+	token, tokenErr := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		var key interface{}
+
+		key, keyErr = keyFunc(token)
+
+		return key, keyErr
+	}, options...)
+
+	if tokenErr != nil && keyErr != nil {
+		return nil, nil, keyErr
+	}
 
 	if tokenErr != nil {
-		return nil, tokenErr
+		return nil, nil, tokenErr
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 
 	if !ok {
-		return nil, errors.New("failed to parse claims from JWT")
+		return nil, nil, errors.New("failed to parse claims from JWT")
 	}
 
 	if !token.Valid {
-		return nil, errors.New("the JWT was invalid")
+		return nil, nil, errors.New("the JWT was invalid")
 	}
 
-	return claims, nil
+	return token, claims, nil
 }
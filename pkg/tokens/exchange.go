@@ -0,0 +1,235 @@
+package tokens
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/gookit/color"
+)
+
+// ExchangeOptions describe where the tokens to exchange come from, and what to ask for in return
+type ExchangeOptions struct {
+	SubjectConnection  string
+	SubjectFile        string
+	SubjectTokenType   string
+	ActorConnection    string
+	ActorFile          string
+	ActorTokenType     string
+	Audience           string
+	Resource           string
+	RequestedTokenType string
+	Scope              string
+	SaveAs             string
+}
+
+// expandTokenType accepts short names like `access_token`, as well as the full URNs
+func expandTokenType(tokenType string) string {
+	switch tokenType {
+	case "access_token":
+		return oidc.AccessTokenType
+	case "refresh_token":
+		return oidc.RefreshTokenType
+	case "id_token":
+		return oidc.IdTokenType
+	case "jwt":
+		return oidc.JwtTokenType
+	default:
+		return tokenType
+	}
+}
+
+// readToken loads a token from a stored connection, a file, or stdin when the file is `-`
+func readToken(database *db.CredentialStore, connection string, file string, tokenType string) (string, error) {
+	if connection != "" {
+		tokenSet := LoadTokens(database, connection, "", false)
+
+		switch tokenType {
+		case oidc.IdTokenType:
+			return tokenSet.IdentityToken, nil
+		case oidc.RefreshTokenType:
+			return tokenSet.RefreshToken, nil
+		default:
+			return tokenSet.AccessToken, nil
+		}
+	}
+
+	if file == "" {
+		return "", nil
+	}
+
+	var data []byte
+	var readErr error
+
+	if file == "-" {
+		data, readErr = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, readErr = ioutil.ReadFile(file)
+	}
+
+	if readErr != nil {
+		return "", readErr
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Exchange swaps a subject token for a new token, using the connection's client
+// https://datatracker.ietf.org/doc/html/rfc8693
+func Exchange(database *db.CredentialStore, clientName string, options ExchangeOptions) {
+	exists, existsErr := database.ClientExists(clientName)
+
+	if existsErr != nil || !exists {
+		log.Fatalln("Client doesn't exist")
+	}
+
+	if options.SaveAs != "" {
+		saveExists, saveExistsErr := database.ClientExists(options.SaveAs)
+
+		if saveExistsErr != nil || !saveExists {
+			log.Fatalf("Can't save the exchanged token under %q, because the connection doesn't exist. Create it using `xoauth setup`.", options.SaveAs)
+		}
+	}
+
+	// Stdin can only be read once
+	if options.SubjectConnection == "" && options.ActorConnection == "" && options.SubjectFile == "-" && options.ActorFile == "-" {
+		log.Fatalln("Only one of --subject-token-file and --actor-token-file can read from stdin")
+	}
+
+	allClients, allClientsErr := database.GetClients()
+
+	if allClientsErr != nil {
+		log.Fatalln(allClientsErr)
+	}
+
+	clientConfig, clientErr := database.GetClientWithSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatalln(clientErr)
+	}
+
+	var request = oidc.TokenExchangeRequest{
+		SubjectTokenType:   expandTokenType(options.SubjectTokenType),
+		ActorTokenType:     expandTokenType(options.ActorTokenType),
+		Audience:           options.Audience,
+		Resource:           options.Resource,
+		RequestedTokenType: expandTokenType(options.RequestedTokenType),
+		Scope:              options.Scope,
+	}
+
+	subjectToken, subjectErr := readToken(database, options.SubjectConnection, options.SubjectFile, request.SubjectTokenType)
+
+	if subjectErr != nil {
+		log.Fatalln(subjectErr)
+	}
+
+	if subjectToken == "" {
+		log.Fatalln("Please supply a subject token with --subject-from, or --subject-token-file")
+	}
+
+	actorToken, actorErr := readToken(database, options.ActorConnection, options.ActorFile, request.ActorTokenType)
+
+	if actorErr != nil {
+		log.Fatalln(actorErr)
+	}
+
+	request.SubjectToken = subjectToken
+	request.ActorToken = actorToken
+
+	metadata, metadataErr := clientConfig.Metadata()
+
+	if metadataErr != nil {
+		oidc.ExitWithError(metadataErr)
+	}
+
+	result, exchangeErr := oidc.ExchangeToken(metadata.TokenEndpoint, clientConfig.Authentication(), request)
+
+	if exchangeErr != nil {
+		oidc.ExitWithError(exchangeErr)
+	}
+
+	if options.SaveAs != "" {
+		// The connection would hand out an ID token or refresh token as if it were an access token
+		if result.IssuedTokenType != oidc.AccessTokenType && result.IssuedTokenType != oidc.JwtTokenType {
+			log.Fatalf("Can't save the exchanged token under %q, because it isn't an access token (issued_token_type %q)", options.SaveAs, result.IssuedTokenType)
+		}
+
+		saveConfig, saveConfigErr := database.GetClientWithSecret(allClients, options.SaveAs)
+
+		if saveConfigErr != nil {
+			log.Fatalln(saveConfigErr)
+		}
+
+		if saveConfig.AccessTokenValidationPolicy() != oidc.AccessTokenValidationNone {
+			var saveMetadata = metadata
+
+			if saveConfig.Authority != clientConfig.Authority {
+				var saveMetadataErr error
+				saveMetadata, saveMetadataErr = saveConfig.Metadata()
+
+				if saveMetadataErr != nil {
+					oidc.ExitWithError(saveMetadataErr)
+				}
+			}
+
+			validateErr := oidc.ValidateAccessToken(result.AccessToken, saveMetadata, saveConfig.AccessTokenPolicy())
+
+			if validateErr != nil {
+				oidc.ExitWithError(validateErr)
+			}
+		}
+
+		log.Printf("Storing exchanged token in local keychain as %q", options.SaveAs)
+
+		// Only the access token is replaced, so the connection keeps its own refresh token and ID token
+		savedSet, savedErr := database.GetTokens(options.SaveAs)
+
+		if savedErr != nil {
+			savedSet = oidc.TokenResultSet{TokenEndpoint: metadata.TokenEndpoint}
+		}
+
+		if result.RefreshToken != "" {
+			if options.SaveAs == clientName {
+				savedSet.RefreshToken = result.RefreshToken
+				savedSet.TokenEndpoint = metadata.TokenEndpoint
+			} else {
+				// The refresh token was issued to this connection's client, so the other connection couldn't use it
+				log.Printf("%s", color.Yellow.Sprintf("Not saving the refresh token under %q, because it was issued to %q's client", options.SaveAs, clientName))
+			}
+		}
+
+		savedSet.AccessToken = result.AccessToken
+		savedSet.TokenType = result.TokenType
+		savedSet.ExpiresIn = result.ExpiresIn
+		savedSet.ExpiresAt = result.ExpiresAt
+		savedSet.Scope = result.Scope
+		savedSet.AuthorisationDetails = nil
+
+		_, tokenSaveErr := database.SaveTokens(options.SaveAs, savedSet)
+
+		// Can fail with warning
+		if tokenSaveErr != nil {
+			log.Printf("%s: %v",
+				color.Yellow.Sprintf("failed to save tokens to keychain"),
+				tokenSaveErr,
+			)
+		}
+	}
+
+	jsonData, jsonErr := json.MarshalIndent(result, "", "    ")
+
+	if jsonErr != nil {
+		log.Fatalln(jsonErr)
+	}
+
+	_, finalWriteErr := fmt.Fprintln(os.Stdout, string(jsonData))
+
+	if finalWriteErr != nil {
+		log.Fatalln(finalWriteErr)
+	}
+}
@@ -0,0 +1,184 @@
+package tokens
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/XeroAPI/xoauth/pkg/config"
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+)
+
+// LoadResourceTokens reads the saved access token for one of the connection's resources. If there isn't one,
+// or it has expired, the connection's refresh token is used to get one restricted to that resource. Client
+// credentials and JWT bearer connections ask for it with their own grant instead.
+// Given a scope, a new token is always requested, restricted to those scopes too
+// https://datatracker.ietf.org/doc/html/rfc8707#section-2.2
+func LoadResourceTokens(database *db.CredentialStore, clientName string, resource string, scope string, forceRefresh bool) oidc.TokenResultSet {
+	allClients, allClientsErr := database.GetClients()
+
+	if allClientsErr != nil {
+		log.Fatalln(allClientsErr)
+	}
+
+	clientConfig, clientErr := database.GetClientWithSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatalln(clientErr)
+	}
+
+	// The refresh token can only be used for resources the user agreed to when connecting
+	if !config.Contains(clientConfig.Resources, resource) {
+		log.Fatalf("%q isn't one of the connection's resources. Add it with `xoauth setup add-resource`, then connect again", resource)
+	}
+
+	resourceTokens, resourceTokensErr := database.GetResourceTokens(clientName, resource)
+	var saved = resourceTokensErr == nil && resourceTokens.AccessToken != ""
+
+	if saved && !forceRefresh && scope == "" && resourceTokens.ExpiresAt > time.Now().Unix() {
+		return resourceTokens
+	}
+
+	if oidc.IsOffline() {
+		if !saved {
+			log.Fatalf("There's no saved token for %q, and xoauth is offline so can't request one", resource)
+		}
+
+		log.Println("The resource's token has expired, but xoauth is offline so it won't be refreshed")
+		return resourceTokens
+	}
+
+	metadata, metadataErr := clientConfig.Metadata()
+
+	if metadataErr != nil {
+		oidc.ExitWithError(metadataErr)
+	}
+
+	var refreshResult oidc.RefreshResult
+	var tokenEndpoint = metadata.TokenEndpoint
+	var requestScope = scope
+
+	if requestScope == "" {
+		requestScope = strings.Join(clientConfig.Scopes, " ")
+	}
+
+	switch clientConfig.GrantType {
+	// Without a refresh token, the resource is asked for with the connection's own grant
+	case oidc.ClientCredentials:
+		tokenResult, tokenErr := oidc.RequestWithClientCredentials(tokenEndpoint,
+			clientConfig.Authentication(),
+			requestScope,
+			clientConfig.AuthorisationDetails,
+			[]string{resource},
+		)
+
+		if tokenErr != nil {
+			oidc.ExitWithError(tokenErr)
+		}
+
+		refreshResult = oidc.RefreshResult{
+			AccessToken: tokenResult.AccessToken,
+			ExpiresIn:   tokenResult.ExpiresIn,
+			TokenType:   tokenResult.TokenType,
+		}
+	case oidc.JwtBearer:
+		tokenResult, tokenErr := oidc.RequestWithJwtBearer(tokenEndpoint,
+			clientConfig.Authentication(),
+			clientConfig.Assertion,
+			requestScope,
+			[]string{resource},
+		)
+
+		if tokenErr != nil {
+			oidc.ExitWithError(tokenErr)
+		}
+
+		refreshResult = oidc.RefreshResult{
+			AccessToken: tokenResult.AccessToken,
+			ExpiresIn:   tokenResult.ExpiresIn,
+			TokenType:   tokenResult.TokenType,
+			Scope:       tokenResult.Scope,
+		}
+	default:
+		tokenSet := LoadTokens(database, clientName, "", false)
+
+		if tokenSet.RefreshToken == "" {
+			log.Fatalln("No refresh token is present in the saved credentials - unable to request a token for the resource")
+		}
+
+		if tokenSet.TokenEndpoint != "" {
+			tokenEndpoint = tokenSet.TokenEndpoint
+		}
+
+		var refreshErr error
+
+		refreshResult, refreshErr = oidc.RefreshToken(tokenEndpoint,
+			clientConfig.Authentication(),
+			tokenSet.RefreshToken,
+			resource,
+			scope,
+		)
+
+		if refreshErr != nil {
+			oidc.ExitWithError(refreshErr)
+		}
+
+		// A rotated refresh token replaces the connection's, or the next request would fail
+		if refreshResult.RefreshToken != "" && refreshResult.RefreshToken != tokenSet.RefreshToken {
+			tokenSet.RefreshToken = refreshResult.RefreshToken
+
+			_, saveErr := database.SaveTokens(clientName, tokenSet)
+
+			if saveErr != nil {
+				log.Fatalln(saveErr)
+			}
+		}
+	}
+
+	if clientConfig.AccessTokenValidationPolicy() != oidc.AccessTokenValidationNone {
+		// The token is for the resource, whatever audience the connection's main tokens are for
+		var policy = clientConfig.AccessTokenPolicy()
+		policy.Audience = resource
+
+		if scope != "" {
+			policy.Scopes = strings.Fields(scope)
+		}
+
+		validateErr := oidc.ValidateAccessToken(refreshResult.AccessToken, metadata, policy)
+
+		if validateErr != nil {
+			oidc.ExitWithError(validateErr)
+		}
+	}
+
+	// Without a scope in the response, the requested scopes were granted
+	// https://tools.ietf.org/html/rfc6749#section-5.1
+	var grantedScope = refreshResult.Scope
+
+	if grantedScope == "" {
+		grantedScope = scope
+	}
+
+	resourceTokens = oidc.TokenResultSet{
+		AccessToken:   refreshResult.AccessToken,
+		TokenType:     refreshResult.TokenType,
+		ExpiresIn:     refreshResult.ExpiresIn,
+		ExpiresAt:     oidc.AbsoluteExpiry(time.Now(), refreshResult.ExpiresIn),
+		Scope:         grantedScope,
+		TokenEndpoint: tokenEndpoint,
+	}
+
+	// A down-scoped access token is only for this command, so the resource's saved token keeps all of its scopes
+	if scope != "" {
+		return resourceTokens
+	}
+
+	_, saveErr := database.SaveResourceTokens(clientName, resource, resourceTokens)
+
+	if saveErr != nil {
+		log.Fatalln(saveErr)
+	}
+
+	return resourceTokens
+}
@@ -6,13 +6,28 @@ import (
 	"log"
 	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/XeroAPI/xoauth/pkg/db"
 	"github.com/XeroAPI/xoauth/pkg/oidc"
+	"github.com/gookit/color"
 )
 
-func ShowTokens(database *db.CredentialStore, clientName string, exportToEnv bool, forceRefresh bool) {
+func ShowTokens(database *db.CredentialStore, clientName string, resource string, scope string, exportToEnv bool, forceRefresh bool) {
+	tokenSet := loadTokensForResource(database, clientName, resource, scope, forceRefresh)
+
+	if exportToEnv {
+		PrintEnvVars(clientName, tokenSet)
+		return
+	}
+
+	PrintJson(tokenSet)
+}
+
+// LoadTokens reads the saved token set, refreshing it first if it has expired.
+// Given a scope, it's always refreshed, asking for only those scopes
+func LoadTokens(database *db.CredentialStore, clientName string, scope string, forceRefresh bool) oidc.TokenResultSet {
 	exists, existsErr := database.ClientExists(clientName)
 
 	if existsErr != nil || !exists {
@@ -25,22 +40,70 @@ func ShowTokens(database *db.CredentialStore, clientName string, exportToEnv boo
 		log.Fatalln(tokenErr)
 	}
 
-	if tokenSet.ExpiresAt <= time.Now().Unix() || forceRefresh {
+	var expired = tokenSet.ExpiresAt <= time.Now().Unix()
+
+	if scope != "" {
+		forceRefresh = true
+	}
+
+	if (expired || forceRefresh) && oidc.IsOffline() {
+		if expired {
+			log.Println("The tokens have expired, but xoauth is offline so they won't be refreshed")
+		}
+
+		return tokenSet
+	}
+
+	if expired || forceRefresh {
 		var err error
 
-		tokenSet, err = Refresh(database, clientName, tokenSet)
+		tokenSet, err = Refresh(database, clientName, tokenSet, scope)
 
 		if err != nil {
-			log.Fatalln(err)
+			oidc.ExitWithError(err)
 		}
 	}
 
-	if exportToEnv {
-		PrintEnvVars(clientName, tokenSet)
-		return
+	return tokenSet
+}
+
+// loadTokensForResource loads the token set for one of the connection's resources, or its main token set when there's no resource
+func loadTokensForResource(database *db.CredentialStore, clientName string, resource string, scope string, forceRefresh bool) oidc.TokenResultSet {
+	if resource != "" {
+		return LoadResourceTokens(database, clientName, resource, scope, forceRefresh)
 	}
 
-	PrintJson(tokenSet)
+	return LoadTokens(database, clientName, scope, forceRefresh)
+}
+
+// ShowDPoPProof prints a DPoP proof for calling a resource server with the saved access token
+// https://datatracker.ietf.org/doc/html/rfc9449#section-7
+func ShowDPoPProof(database *db.CredentialStore, clientName string, resource string, method string, resourceUrl string, forceRefresh bool) {
+	tokenSet := loadTokensForResource(database, clientName, resource, "", forceRefresh)
+
+	allClients, allClientsErr := database.GetClients()
+
+	if allClientsErr != nil {
+		log.Fatalln(allClientsErr)
+	}
+
+	clientConfig, clientErr := database.GetClientWithSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatalln(clientErr)
+	}
+
+	if !clientConfig.UseDPoP {
+		log.Fatalf("DPoP isn't enabled for %q", clientName)
+	}
+
+	proof, proofErr := oidc.BuildDPoPProof(clientConfig.DPoPKey, method, resourceUrl, "", tokenSet.AccessToken)
+
+	if proofErr != nil {
+		log.Fatalln(proofErr)
+	}
+
+	fmt.Fprint(os.Stdout, proof)
 }
 
 func PrintEnvVars(clientName string, tokenSet oidc.TokenResultSet) {
@@ -74,7 +137,11 @@ func PrintJson(tokenSet oidc.TokenResultSet) {
 	fmt.Fprintf(os.Stdout, "%s", tokenSerialised)
 }
 
-func Refresh(database *db.CredentialStore, clientName string, tokenSet oidc.TokenResultSet) (oidc.TokenResultSet, error) {
+// Refresh uses the saved refresh token to get new tokens. Given a scope, the new access token is
+// restricted to those scopes. Anything the provider doesn't return again, such as a refresh token
+// it hasn't rotated, is kept from the saved token set
+// https://tools.ietf.org/html/rfc6749#section-6
+func Refresh(database *db.CredentialStore, clientName string, tokenSet oidc.TokenResultSet, scope string) (oidc.TokenResultSet, error) {
 	allClients, allClientsErr := database.GetClients()
 	if allClientsErr != nil {
 		log.Fatalln(allClientsErr)
@@ -86,30 +153,138 @@ func Refresh(database *db.CredentialStore, clientName string, tokenSet oidc.Toke
 		return tokenSet, err
 	}
 
+	// There's no refresh token for the jwt_bearer grant, so mint a new assertion instead
+	if clientConfig.GrantType == oidc.JwtBearer {
+		return remintWithJwtBearer(database, clientConfig)
+	}
+
 	if tokenSet.RefreshToken == "" {
 		log.Fatalln("No refresh token is present in the saved credentials - unable to perform a refresh")
 	}
 
-	refreshResult, refreshErr := oidc.RefreshToken(clientConfig.Authority,
-		clientConfig.ClientId,
-		clientConfig.ClientSecret,
+	metadata, metadataErr := clientConfig.Metadata()
+
+	if metadataErr != nil {
+		return tokenSet, metadataErr
+	}
+
+	// Token sets saved before the endpoint was recorded are refreshed at the provider's current one
+	var tokenEndpoint = tokenSet.TokenEndpoint
+
+	if tokenEndpoint == "" {
+		tokenEndpoint = metadata.TokenEndpoint
+	}
+
+	refreshResult, refreshErr := oidc.RefreshToken(tokenEndpoint,
+		clientConfig.Authentication(),
 		tokenSet.RefreshToken,
+		"",
+		scope,
 	)
 
 	if refreshErr != nil {
 		return tokenSet, refreshErr
 	}
 
-	tokenSet.RefreshToken = refreshResult.RefreshToken
-	tokenSet.AccessToken = refreshResult.AccessToken
-	tokenSet.ExpiresIn = refreshResult.ExpiresIn
-	tokenSet.ExpiresAt = oidc.AbsoluteExpiry(time.Now(), refreshResult.ExpiresIn)
+	if clientConfig.AccessTokenValidationPolicy() != oidc.AccessTokenValidationNone {
+		var policy = clientConfig.AccessTokenPolicy()
+
+		// Only the down-scoped scopes are expected
+		if scope != "" {
+			policy.Scopes = strings.Fields(scope)
+		}
+
+		validateErr := oidc.ValidateAccessToken(refreshResult.AccessToken, metadata, policy)
+
+		if validateErr != nil {
+			return tokenSet, validateErr
+		}
+	}
+
+	if refreshResult.IdentityToken != "" {
+		_, idTokenErr := oidc.ValidateRefreshedIdToken(refreshResult.IdentityToken, tokenSet.IdentityToken, metadata, oidc.IdTokenExpectations{
+			ClientId:          clientConfig.ClientId,
+			MaxAge:            oidc.NoMaxAge,
+			AccessToken:       refreshResult.AccessToken,
+			AllowedAlgorithms: clientConfig.AllowedAlgorithms,
+		})
+
+		if idTokenErr != nil {
+			return tokenSet, idTokenErr
+		}
+
+		tokenSet.IdentityToken = refreshResult.IdentityToken
+	}
+
+	// The provider may keep the same refresh token, or rotate it
+	// https://datatracker.ietf.org/doc/html/draft-ietf-oauth-security-topics#section-4.14.2
+	if refreshResult.RefreshToken != "" {
+		tokenSet.RefreshToken = refreshResult.RefreshToken
+	}
+
+	tokenSet.TokenEndpoint = tokenEndpoint
+
+	var refreshedSet = tokenSet
+
+	// Without a scope in the response, the requested scopes were granted
+	// https://tools.ietf.org/html/rfc6749#section-5.1
+	if refreshResult.Scope != "" {
+		refreshedSet.Scope = refreshResult.Scope
+	} else if scope != "" {
+		refreshedSet.Scope = scope
+	}
+
+	if refreshResult.AuthorisationDetails != nil {
+		refreshedSet.AuthorisationDetails = refreshResult.AuthorisationDetails
+	}
+
+	refreshedSet.AccessToken = refreshResult.AccessToken
+	refreshedSet.TokenType = refreshResult.TokenType
+	refreshedSet.ExpiresIn = refreshResult.ExpiresIn
+	refreshedSet.ExpiresAt = oidc.AbsoluteExpiry(time.Now(), refreshResult.ExpiresIn)
+
+	// A down-scoped access token is only for this command. The saved access token keeps all of the
+	// connection's scopes, so only a rotated refresh token or a new ID token is saved
+	if scope == "" {
+		tokenSet = refreshedSet
+	}
 
-	_, saveErr := database.SaveTokens(clientName, oidc.TokenResultSet{
-		RefreshToken: refreshResult.RefreshToken,
-		AccessToken:  refreshResult.AccessToken,
-		ExpiresAt:    tokenSet.ExpiresAt,
-	})
+	_, saveErr := database.SaveTokens(clientName, tokenSet)
+
+	if saveErr != nil {
+		return refreshedSet, saveErr
+	}
+
+	return refreshedSet, nil
+}
+
+func remintWithJwtBearer(database *db.CredentialStore, clientConfig db.OidcClient) (oidc.TokenResultSet, error) {
+	var tokenSet oidc.TokenResultSet
+
+	metadata, metadataErr := clientConfig.Metadata()
+
+	if metadataErr != nil {
+		return tokenSet, metadataErr
+	}
+
+	tokenSet, tokenErr := oidc.RequestWithJwtBearer(metadata.TokenEndpoint,
+		clientConfig.Authentication(),
+		clientConfig.Assertion,
+		strings.Join(clientConfig.Scopes, " "),
+		clientConfig.Resources,
+	)
+
+	if tokenErr != nil {
+		return tokenSet, tokenErr
+	}
+
+	validateErr := oidc.ValidateAccessToken(tokenSet.AccessToken, metadata, clientConfig.AccessTokenPolicy())
+
+	if validateErr != nil {
+		return tokenSet, validateErr
+	}
+
+	_, saveErr := database.SaveTokens(clientConfig.Alias, tokenSet)
 
 	if saveErr != nil {
 		return tokenSet, saveErr
@@ -134,3 +309,174 @@ func CleanTokens(database *db.CredentialStore, clientName string) error {
 
 	return nil
 }
+
+// RevokeTokens revokes the stored tokens at the provider, then removes them from the keychain
+func RevokeTokens(database *db.CredentialStore, clientName string, localOnly bool) {
+	exists, existsErr := database.ClientExists(clientName)
+
+	if existsErr != nil || !exists {
+		log.Fatalln("Client doesn't exist")
+	}
+
+	if !localOnly {
+		allClients, allClientsErr := database.GetClients()
+
+		if allClientsErr != nil {
+			log.Fatalln(allClientsErr)
+		}
+
+		clientConfig, clientErr := database.GetClientWithSecret(allClients, clientName)
+
+		if clientErr != nil {
+			log.Fatalln(clientErr)
+		}
+
+		tokenSet, tokenErr := database.GetTokens(clientName)
+
+		if tokenErr != nil {
+			log.Println(tokenErr)
+			log.Fatalln("No tokens to revoke")
+		}
+
+		metadata, metadataErr := clientConfig.Metadata()
+
+		if metadataErr != nil {
+			oidc.ExitWithError(metadataErr)
+		}
+
+		if metadata.RevocationEndpoint == "" {
+			log.Fatalf("%q does not advertise a revocation_endpoint, so the tokens can't be revoked. "+
+				"Use `xoauth revoke %s --local-only` to remove them from this machine only",
+				metadata.Issuer,
+				clientName,
+			)
+		}
+
+		// Revoke the refresh token first - many providers revoke the
+		// associated access tokens along with it
+		if tokenSet.RefreshToken != "" {
+			revokeErr := oidc.RevokeToken(metadata.RevocationEndpoint,
+				clientConfig.Authentication(),
+				tokenSet.RefreshToken,
+				oidc.RefreshTokenHint,
+			)
+
+			if revokeErr != nil {
+				oidc.ExitWithError(revokeErr)
+			}
+		}
+
+		if tokenSet.AccessToken != "" {
+			revokeErr := oidc.RevokeToken(metadata.RevocationEndpoint,
+				clientConfig.Authentication(),
+				tokenSet.AccessToken,
+				oidc.AccessTokenHint,
+			)
+
+			// Once the refresh token is revoked the saved tokens are no use, so they're removed anyway.
+			// Providers may refuse to revoke access tokens with unsupported_token_type
+			// https://tools.ietf.org/html/rfc7009#section-2.2.1
+			if revokeErr != nil && tokenSet.RefreshToken != "" {
+				log.Printf("%s: %v", color.Yellow.Sprintf("The refresh token was revoked, but the access token couldn't be"), revokeErr)
+			} else if revokeErr != nil {
+				oidc.ExitWithError(revokeErr)
+			}
+		}
+
+		log.Println("Tokens revoked")
+	}
+
+	err := database.DeleteTokens(clientName)
+
+	if err != nil {
+		log.Println(err)
+		log.Fatalln("Error deleting tokens")
+	}
+
+	log.Println("Tokens removed from local keychain")
+}
+
+// Introspect asks the provider about a token. If no token is supplied, the stored access token is used
+func Introspect(database *db.CredentialStore, clientName string, token string, asTable bool) {
+	exists, existsErr := database.ClientExists(clientName)
+
+	if existsErr != nil || !exists {
+		log.Fatalln("Client doesn't exist")
+	}
+
+	allClients, allClientsErr := database.GetClients()
+
+	if allClientsErr != nil {
+		log.Fatalln(allClientsErr)
+	}
+
+	clientConfig, clientErr := database.GetClientWithSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatalln(clientErr)
+	}
+
+	var tokenTypeHint = ""
+
+	if token == "" {
+		tokenSet, tokenErr := database.GetTokens(clientName)
+
+		if tokenErr != nil {
+			log.Fatalln(tokenErr)
+		}
+
+		token = tokenSet.AccessToken
+		tokenTypeHint = oidc.AccessTokenHint
+	}
+
+	metadata, metadataErr := clientConfig.Metadata()
+
+	if metadataErr != nil {
+		oidc.ExitWithError(metadataErr)
+	}
+
+	result, introspectErr := oidc.IntrospectToken(metadata.IntrospectionEndpoint,
+		clientConfig.Authentication(),
+		token,
+		tokenTypeHint,
+	)
+
+	if introspectErr != nil {
+		oidc.ExitWithError(introspectErr)
+	}
+
+	if asTable {
+		PrintIntrospectionTable(result)
+		return
+	}
+
+	resultSerialised, resultSerialisedErr := json.MarshalIndent(result, "", "  ")
+
+	if resultSerialisedErr != nil {
+		log.Fatalln(resultSerialisedErr)
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", resultSerialised)
+}
+
+func PrintIntrospectionTable(result oidc.IntrospectionResult) {
+	var expiry = ""
+
+	if result.Exp != 0 {
+		expiry = fmt.Sprintf("%d (%s)", result.Exp, time.Unix(result.Exp, 0).Format(time.RFC1123))
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(writer, "active\t%t\n", result.Active)
+	fmt.Fprintf(writer, "scope\t%s\n", result.Scope)
+	fmt.Fprintf(writer, "exp\t%s\n", expiry)
+	fmt.Fprintf(writer, "sub\t%s\n", result.Sub)
+	fmt.Fprintf(writer, "client_id\t%s\n", result.ClientId)
+
+	flushErr := writer.Flush()
+
+	if flushErr != nil {
+		log.Fatalln(flushErr)
+	}
+}
@@ -0,0 +1,109 @@
+package tokens
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/XeroAPI/xoauth/pkg/db"
+	"github.com/XeroAPI/xoauth/pkg/oidc"
+)
+
+// Claims about the ID token itself, rather than the user, which are left out of the merged identity
+var idTokenProtocolClaims = []string{"aud", "azp", "exp", "iat", "nbf", "jti", "nonce", "at_hash", "c_hash", "s_hash", "sid"}
+
+// UserInfo prints who the stored tokens belong to: the ID token's claims, merged with the claims from the userinfo endpoint
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func UserInfo(database *db.CredentialStore, clientName string, forceRefresh bool) {
+	tokenSet := LoadTokens(database, clientName, "", forceRefresh)
+
+	if tokenSet.AccessToken == "" {
+		log.Fatalln("No access token is present in the saved credentials. Use `xoauth connect` first")
+	}
+
+	allClients, allClientsErr := database.GetClients()
+
+	if allClientsErr != nil {
+		log.Fatalln(allClientsErr)
+	}
+
+	clientConfig, clientErr := database.GetClientWithSecret(allClients, clientName)
+
+	if clientErr != nil {
+		log.Fatalln(clientErr)
+	}
+
+	metadata, metadataErr := clientConfig.Metadata()
+
+	if metadataErr != nil {
+		oidc.ExitWithError(metadataErr)
+	}
+
+	userInfo, userInfoErr := oidc.RequestUserInfo(metadata,
+		clientConfig.Authentication(),
+		tokenSet.AccessToken,
+		tokenSet.TokenType,
+		clientConfig.AllowedAlgorithms,
+	)
+
+	if userInfoErr != nil {
+		oidc.ExitWithError(userInfoErr)
+	}
+
+	identity, mergeErr := mergeIdentity(tokenSet.IdentityToken, userInfo)
+
+	if mergeErr != nil {
+		log.Fatalln(mergeErr)
+	}
+
+	identitySerialised, identitySerialisedErr := json.MarshalIndent(identity, "", "  ")
+
+	if identitySerialisedErr != nil {
+		log.Fatalln(identitySerialisedErr)
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", identitySerialised)
+}
+
+// mergeIdentity combines the ID token's claims with the userinfo claims, which take precedence as they're fresher.
+// The userinfo sub must match the ID token's, or the response could be for a different user
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+func mergeIdentity(idToken string, userInfo map[string]interface{}) (map[string]interface{}, error) {
+	var identity = map[string]interface{}{}
+
+	userInfoSubject, _ := userInfo["sub"].(string)
+
+	if userInfoSubject == "" {
+		return nil, fmt.Errorf("the userinfo response has no sub claim")
+	}
+
+	if idToken == "" {
+		log.Println("There's no saved ID token, so only the userinfo claims are shown")
+		return userInfo, nil
+	}
+
+	idTokenClaims, claimsErr := oidc.UnverifiedClaims(idToken)
+
+	if claimsErr != nil {
+		return nil, claimsErr
+	}
+
+	if idTokenSubject, _ := idTokenClaims["sub"].(string); idTokenSubject != userInfoSubject {
+		return nil, fmt.Errorf("the userinfo sub %q doesn't match the ID token sub %q", userInfoSubject, idTokenSubject)
+	}
+
+	for name, value := range idTokenClaims {
+		identity[name] = value
+	}
+
+	for _, name := range idTokenProtocolClaims {
+		delete(identity, name)
+	}
+
+	for name, value := range userInfo {
+		identity[name] = value
+	}
+
+	return identity, nil
+}